@@ -0,0 +1,187 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrBitLength        = errors.New("bitarray: bit length out of range")
+	ErrInvalidBitString = errors.New("bitarray: invalid bit string literal")
+)
+
+// A BitString is an immutable sequence of bits of any length. The zero
+// value is the empty bit string.
+type BitString struct {
+	words []uint64 // bits, most significant first; bits past n are zero.
+	n     int      // number of bits.
+}
+
+// NewBitString returns the bit string of the first n bits of data.
+func NewBitString(data []byte, n int) (BitString, error) {
+	if n < 0 || n > len(data)*8 {
+		return BitString{}, ErrBitLength
+	}
+	var bld builder
+	for i := 0; i < n; i += 8 {
+		k := n - i
+		if k > 8 {
+			k = 8
+		}
+		bld.push(uint64(data[i/8])>>uint(8-k), k)
+	}
+	return bld.bitString(), nil
+}
+
+// Len returns the number of bits in s.
+func (s BitString) Len() int {
+	return s.n
+}
+
+// Bit reports whether the i-th bit of s is set. It panics if i is out of
+// range.
+func (s BitString) Bit(i int) bool {
+	if i < 0 || i >= s.n {
+		panic("bitarray: BitString index out of range")
+	}
+	return s.words[i/64]>>uint(63-i%64)&1 != 0
+}
+
+// Slice returns the bits of s from from up to but not including to. It
+// panics if the range is invalid.
+func (s BitString) Slice(from, to int) BitString {
+	if from < 0 || to < from || to > s.n {
+		panic("bitarray: BitString slice bounds out of range")
+	}
+	var bld builder
+	bld.pushBits(s, from, to)
+	return bld.bitString()
+}
+
+// Concat returns the bits of s followed by the bits of each of others.
+func (s BitString) Concat(others ...BitString) BitString {
+	var bld builder
+	bld.pushBits(s, 0, s.n)
+	for _, o := range others {
+		bld.pushBits(o, 0, o.n)
+	}
+	return bld.bitString()
+}
+
+// Equal reports whether s and o have the same length and bits.
+func (s BitString) Equal(o BitString) bool {
+	if s.n != o.n {
+		return false
+	}
+	for i := range s.words {
+		if s.words[i] != o.words[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Bytes returns the bits of s packed into bytes, with the last byte padded
+// with zero bits.
+func (s BitString) Bytes() []byte {
+	data := make([]byte, (s.n+7)/8)
+	for i := range data {
+		data[i] = byte(s.words[i/8] >> uint(56-8*(i%8)))
+	}
+	return data
+}
+
+// Reader returns a Buffer that pops the bits of s from its start. The
+// Buffer returns io.EOF at the end of s, even within a byte.
+func (s BitString) Reader() *Buffer {
+	b := NewBuffer(bytes.NewReader(s.Bytes()))
+	b.limit, b.limited = uint64(s.n), true
+	return b
+}
+
+// String returns s as a binary literal accepted by ParseBitString.
+func (s BitString) String() string {
+	var sb strings.Builder
+	sb.WriteString("0b")
+	for i := 0; i < s.n; i++ {
+		if s.Bit(i) {
+			sb.WriteByte('1')
+		} else {
+			sb.WriteByte('0')
+		}
+	}
+	return sb.String()
+}
+
+// bits returns k bits of s from bit offset i, right aligned. Bits past the
+// end of s are zero.
+func (s BitString) bits(i, k int) uint64 {
+	if k == 0 {
+		return 0
+	}
+	w, off := i/64, uint(i%64)
+	v := s.words[w] << off
+	if off != 0 && w+1 < len(s.words) {
+		v |= s.words[w+1] >> (64 - off)
+	}
+	return v >> uint(64-k)
+}
+
+// A builder appends bits to make a BitString.
+type builder struct {
+	words []uint64
+	n     int
+}
+
+// push appends the lowest k bits of v, for k up to 64.
+func (b *builder) push(v uint64, k int) {
+	if k == 0 {
+		return
+	}
+	if k < 64 {
+		v &= 1<<uint(k) - 1
+	}
+	off := b.n % 64
+	if off == 0 {
+		b.words = append(b.words, 0)
+	}
+	free := 64 - off
+	if k <= free {
+		b.words[len(b.words)-1] |= v << uint(free-k)
+	} else {
+		b.words[len(b.words)-1] |= v >> uint(k-free)
+		b.words = append(b.words, v<<uint(64-(k-free)))
+	}
+	b.n += k
+}
+
+// pushBits appends the bits of s from from up to to, a word at a time.
+func (b *builder) pushBits(s BitString, from, to int) {
+	for i := from; i < to; i += 64 {
+		k := to - i
+		if k > 64 {
+			k = 64
+		}
+		b.push(s.bits(i, k), k)
+	}
+}
+
+func (b *builder) bitString() BitString {
+	return BitString{words: b.words, n: b.n}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestNewBitString(t *testing.T) {
+	s, err := NewBitString([]byte{0xb7, 0xff}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 10 || s.String() != "0b1011011111" {
+		t.Errorf("want=0b1011011111, out: %v", s)
+	}
+	if want := []byte{0xb7, 0xc0}; !reflect.DeepEqual(want, s.Bytes()) {
+		t.Errorf("want=%x, out: %x", want, s.Bytes())
+	}
+	if _, err := NewBitString([]byte{0xff}, 9); err != ErrBitLength {
+		t.Errorf("want=%v, out: %v", ErrBitLength, err)
+	}
+	if s, _ := NewBitString(nil, 0); !s.Equal(BitString{}) {
+		t.Errorf("want=empty, out: %v", s)
+	}
+}
+
+// randomBits returns a random bit string of n bits and its bits as bools.
+func randomBits(r *rand.Rand, n int) (BitString, []bool) {
+	data := make([]byte, (n+7)/8)
+	r.Read(data)
+	s, _ := NewBitString(data, n)
+	bits := make([]bool, n)
+	for i := range bits {
+		bits[i] = data[i/8]>>(7-uint(i%8))&1 != 0
+	}
+	return s, bits
+}
+
+func checkBits(t *testing.T, s BitString, want []bool) {
+	t.Helper()
+	if s.Len() != len(want) {
+		t.Fatalf("want=%d bits, out: %d", len(want), s.Len())
+	}
+	for i, b := range want {
+		if s.Bit(i) != b {
+			t.Fatalf("bit %d of %v: want=%v", i, s, b)
+		}
+	}
+}
+
+func TestBitStringSliceConcat(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		a, abits := randomBits(r, r.Intn(200))
+		b, bbits := randomBits(r, r.Intn(200))
+		c := a.Concat(b)
+		checkBits(t, c, append(append([]bool{}, abits...), bbits...))
+
+		from := r.Intn(c.Len() + 1)
+		to := from + r.Intn(c.Len()-from+1)
+		checkBits(t, c.Slice(from, to), append(append([]bool{}, abits...), bbits...)[from:to])
+
+		if !c.Slice(0, a.Len()).Equal(a) || !c.Slice(a.Len(), c.Len()).Equal(b) {
+			t.Errorf("want=%v + %v, out: %v", a, b, c)
+		}
+	}
+	s, _ := ParseBitString("0b101")
+	if s.Equal(s.Concat(s).Slice(0, 4)) || !s.Equal(s.Concat(BitString{})) {
+		t.Error("want=Equal to compare length and bits")
+	}
+}
+
+func TestBitStringPanics(t *testing.T) {
+	s, _ := ParseBitString("0b101")
+	for _, f := range []func(){
+		func() { s.Bit(3) },
+		func() { s.Bit(-1) },
+		func() { s.Slice(2, 4) },
+		func() { s.Slice(2, 1) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("want=panic, out: none")
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+// Reader: Bits are popped up to the end of the bit string, within a byte.
+// |1011,0111|111-,----|
+func TestBitStringReader(t *testing.T) {
+	s, _ := NewBitString([]byte{0xb7, 0xff}, 11)
+	b := s.Reader()
+	out, err := b.PopUint8(4)
+	if out != 0x0b || err != nil {
+		t.Errorf("want=%x, out: %x, %v", 0x0b, out, err)
+	}
+	out16, err := b.PopUint16(7)
+	if out16 != 0x3f || err != io.EOF || b.Truncated() {
+		t.Errorf("want=%x and io.EOF, out: %x, %v", 0x3f, out16, err)
+	}
+	if eof, _ := b.AtEOF(); !eof {
+		t.Error("want=EOF at the end of the bit string")
+	}
+
+	b = s.Reader()
+	out16, err = b.PopUint16(11)
+	if out16 != 0x5bf || err != io.EOF || b.Truncated() {
+		t.Errorf("want=%x and io.EOF, out: %x, %v", 0x5bf, out16, err)
+	}
+
+	b = s.Reader()
+	b.PopUint8(8)
+	out, err = b.PopUint8(5)
+	if out != 0x07 || err != io.EOF || !b.Truncated() {
+		t.Errorf("want=%x and truncated, out: %x, %v", 0x07, out, err)
+	}
+
+	var v struct {
+		A uint8  `bits:"3"`
+		B []byte `until:"eof"`
+	}
+	s, _ = ParseBitString("0b101_11110000_1")
+	if err := Unmarshal(s.Reader(), &v); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if v.A != 5 || !reflect.DeepEqual(v.B, []byte{0xf0, 0x01}) {
+		t.Errorf("want=5, f001, out: %#v", v)
+	}
+}
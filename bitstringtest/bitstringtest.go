@@ -0,0 +1,337 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// Package bitstringtest provides random values of tagged structs and round
+// trip assertions for testing code built on package bitstring.
+//
+//	func TestHeader(t *testing.T) {
+//		f := func(h Header) bool { return bitstringtest.AssertRoundTrip(t, &h) }
+//		if err := quick.Check(f, &quick.Config{Values: bitstringtest.Values(f)}); err != nil {
+//			t.Error(err)
+//		}
+//	}
+//
+// Values of until:"eof" fields round trip only if the struct ends on a byte
+// border, since Marshal pads the last byte with zero bits.
+package bitstringtest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	bitstring "github.com/ymotongpoo/go-bitstring"
+)
+
+const (
+	defaultMaxLen   = 8
+	defaultMaxDepth = 4
+)
+
+// A Generator produces random values of tagged structs that are valid for
+// their layout: uints fit their bit width and enum tags, fixed size fields
+// have their size, strings fit their mode, count fields hold the length of
+// the slices that refer to them, and checksum fields hold the CRC Marshal
+// computes for them.
+type Generator struct {
+	Rand     *rand.Rand // source of values; seeded with 1 if nil
+	MaxLen   int        // maximum length of dynamically sized fields; 8 if zero
+	MaxDepth int        // maximum nesting of slices of structs; 4 if zero
+}
+
+// Generate sets the struct v points to to a random value. It fails if v
+// has a field that cannot be given a valid value, such as a string field
+// of an unknown charset.
+func (g *Generator) Generate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("bitstringtest.Generate: invalid type " + rv.Kind().String())
+	}
+	l, err := bitstring.Layout(rv.Elem().Type())
+	if err != nil {
+		return err
+	}
+	if g.Rand == nil {
+		g.Rand = rand.New(rand.NewSource(1))
+	}
+	if err := g.record(l, rv.Elem(), 0); err != nil {
+		return err
+	}
+	if !hasCRC(l) {
+		return nil
+	}
+	out, _, err := RoundTrip(v)
+	if err != nil {
+		return err
+	}
+	copyCRCs(l, rv.Elem(), reflect.ValueOf(out).Elem())
+	return nil
+}
+
+// hasCRC reports whether a record laid out as l, or one nested in it, has a
+// checksum field.
+func hasCRC(l *bitstring.StructLayout) bool {
+	for i := range l.Fields {
+		f := &l.Fields[i]
+		if f.CRC != nil || f.Kind == bitstring.SliceKind && hasCRC(f.Elem) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyCRCs copies the checksum fields of src to dst, both laid out as l.
+func copyCRCs(l *bitstring.StructLayout, dst, src reflect.Value) {
+	for i := range l.Fields {
+		f := &l.Fields[i]
+		switch {
+		case f.CRC != nil:
+			dst.Field(f.Index).Set(src.Field(f.Index))
+		case f.Kind == bitstring.SliceKind:
+			d, s := dst.Field(f.Index), src.Field(f.Index)
+			for j := 0; j < d.Len() && j < s.Len(); j++ {
+				copyCRCs(f.Elem, d.Index(j), s.Index(j))
+			}
+		}
+	}
+}
+
+func (g *Generator) maxLen() int {
+	if g.MaxLen > 0 {
+		return g.MaxLen
+	}
+	return defaultMaxLen
+}
+
+func (g *Generator) maxDepth() int {
+	if g.MaxDepth > 0 {
+		return g.MaxDepth
+	}
+	return defaultMaxDepth
+}
+
+// record fills the fields of st laid out as l.
+func (g *Generator) record(l *bitstring.StructLayout, st reflect.Value, depth int) error {
+	counted := make(map[string]bool)
+	for i := range l.Fields {
+		f := &l.Fields[i]
+		if f.Name == "_" || f.CRC != nil {
+			continue
+		}
+		v := st.Field(f.Index)
+		switch f.Kind {
+		case bitstring.UintKind:
+			if f.Signed {
+				v.SetInt(bitstring.SignExtend(g.uint(f), f.Bits))
+			} else {
+				v.SetUint(g.uint(f))
+			}
+		case bitstring.BoolKind:
+			v.SetBool(f.Bits > 0 && g.Rand.Intn(2) == 1)
+		case bitstring.BytesKind:
+			n := f.Bits / 8
+			if !f.Static {
+				n = g.length(l, st, f, counted, g.maxLen())
+			}
+			v.SetBytes(g.bytes(n, false, ""))
+		case bitstring.StringKind:
+			s, err := g.string(f)
+			if err != nil {
+				return &bitstring.FieldError{Type: st.Type(), Field: f.Name, Err: err}
+			}
+			v.SetString(s)
+		case bitstring.SliceKind:
+			max := g.maxLen()
+			if depth >= g.maxDepth() {
+				max = 0
+			}
+			n := g.length(l, st, f, counted, max)
+			sl := reflect.MakeSlice(v.Type(), n, n)
+			for j := 0; j < n; j++ {
+				if err := g.record(f.Elem, sl.Index(j), depth+1); err != nil {
+					return err
+				}
+			}
+			v.Set(sl)
+		}
+	}
+	return nil
+}
+
+// uint returns a random value for the UintKind field f.
+func (g *Generator) uint(f *bitstring.FieldLayout) uint64 {
+	if len(f.Enum) > 0 {
+		values := make([]uint64, 0, len(f.Enum))
+		for v := range f.Enum {
+			values = append(values, v)
+		}
+		// Sort for reproducible values from a seeded Rand.
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+		return values[g.Rand.Intn(len(values))]
+	}
+	return g.Rand.Uint64() & mask(f.Bits)
+}
+
+func mask(bits int) uint64 {
+	if bits >= 64 {
+		return ^uint64(0)
+	}
+	return 1<<uint(bits) - 1
+}
+
+// length returns the number of elements of the dynamic field f. A count
+// field is set to the length by the first field that refers to it.
+func (g *Generator) length(l *bitstring.StructLayout, st reflect.Value, f *bitstring.FieldLayout,
+	counted map[string]bool, max int) int {
+	if len(f.Until) != 0 {
+		return g.Rand.Intn(max + 1)
+	}
+	if n, err := strconv.ParseUint(f.Count, 0, 64); err == nil {
+		return int(n)
+	}
+	for _, c := range l.Fields {
+		if c.Name != f.Count {
+			continue
+		}
+		v := st.Field(c.Index)
+		if counted[c.Name] {
+			return int(v.Uint())
+		}
+		if m := mask(c.Bits); uint64(max) > m {
+			max = int(m)
+		}
+		n := g.Rand.Intn(max + 1)
+		v.SetUint(uint64(n))
+		counted[c.Name] = true
+		return n
+	}
+	return 0
+}
+
+// string returns a random value for the StringKind field f.
+func (g *Generator) string(f *bitstring.FieldLayout) (string, error) {
+	var data []byte
+	switch f.Mode {
+	case "":
+		data = g.bytes(f.Bits/8, false, f.Charset)
+	case bitstring.CString:
+		data = g.bytes(g.Rand.Intn(f.Bits/8+1), true, f.Charset)
+	case bitstring.ZString:
+		data = g.bytes(g.Rand.Intn(g.maxLen()+1), true, f.Charset)
+	default:
+		max := g.maxLen()
+		if f.Mode == bitstring.PString8 && max > 0xff {
+			max = 0xff
+		}
+		data = g.bytes(g.Rand.Intn(max+1), false, f.Charset)
+	}
+	s, err := bitstring.DecodeCharset(f.Charset, data)
+	if err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// bytes returns n random bytes. Bytes are nonzero if nonzero is set, and
+// printable ASCII for charsets other than Latin-1, so that they are encoded
+// back to the same bytes.
+func (g *Generator) bytes(n int, nonzero bool, charset string) []byte {
+	data := make([]byte, n)
+	ascii := len(charset) != 0 && !strings.EqualFold(charset, "latin1") && !strings.EqualFold(charset, "iso-8859-1")
+	for i := range data {
+		switch {
+		case ascii:
+			data[i] = byte(0x20 + g.Rand.Intn(0x7f-0x20))
+		case nonzero:
+			data[i] = byte(1 + g.Rand.Intn(0xff))
+		default:
+			data[i] = byte(g.Rand.Intn(0x100))
+		}
+	}
+	return data
+}
+
+// Values returns a function for quick.Config.Values that generates random
+// arguments for f, whose parameters are tagged structs or pointers to them.
+// The function panics with the error of Generate, since quick.Config.Values
+// cannot return one.
+func Values(f interface{}) func([]reflect.Value, *rand.Rand) {
+	ft := reflect.TypeOf(f)
+	return func(args []reflect.Value, r *rand.Rand) {
+		g := &Generator{Rand: r}
+		for i := range args {
+			t := ft.In(i)
+			ptr := t.Kind() == reflect.Ptr
+			if ptr {
+				t = t.Elem()
+			}
+			v := reflect.New(t)
+			if err := g.Generate(v.Interface()); err != nil {
+				panic(err)
+			}
+			if ptr {
+				args[i] = v
+			} else {
+				args[i] = v.Elem()
+			}
+		}
+	}
+}
+
+// RoundTrip encodes the tagged struct v with Marshal, decodes the result
+// with Unmarshal and returns the decoded value, a pointer to a new struct of
+// the same type, with the encoded data.
+func RoundTrip(v interface{}) (interface{}, []byte, error) {
+	data, err := bitstring.Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	out := reflect.New(t)
+	err = bitstring.Unmarshal(bitstring.NewBuffer(bytes.NewReader(data)), out.Interface())
+	if err != nil && err != io.EOF {
+		return nil, data, err
+	}
+	return out.Interface(), data, nil
+}
+
+// AssertRoundTrip reports an error to t unless the tagged struct v is
+// decoded unchanged from its encoding. It returns whether it was.
+func AssertRoundTrip(t testing.TB, v interface{}) bool {
+	t.Helper()
+	out, data, err := RoundTrip(v)
+	if err != nil {
+		t.Errorf("round trip of %T: %v", v, err)
+		return false
+	}
+	in := reflect.ValueOf(v)
+	if in.Kind() == reflect.Ptr {
+		in = in.Elem()
+	}
+	if !reflect.DeepEqual(in.Interface(), reflect.ValueOf(out).Elem().Interface()) {
+		t.Errorf("round trip of %T through %x: want=%#v, out: %#v", v, data, in.Interface(), out)
+		return false
+	}
+	return true
+}
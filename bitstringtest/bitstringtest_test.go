@@ -0,0 +1,158 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstringtest
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	bitstring "github.com/ymotongpoo/go-bitstring"
+)
+
+type level int8
+
+type elem struct {
+	Type  uint8  `bits:"3" enum:"1=A,2=B,5=C"`
+	On    bool   `bits:"1"`
+	Level level  `bits:"3" enum:"-4=Min,-1=Low,3=Max"`
+	Temp  int16  `bits:"5"`
+	Len   uint8  `bits:"4"`
+	Data  []byte `count:"Len"`
+}
+
+type packet struct {
+	Version uint8    `bits:"4"`
+	N       uint8    `bits:"2"`
+	Flag    bool     `bits:"1"`
+	_       uint8    `bits:"1"`
+	Elems   []elem   `count:"N"`
+	Host    string   `binary:"6,cstring"`
+	Raw     string   `binary:"2"`
+	Name    string   `binary:"zstring" charset:"latin1"`
+	Path    string   `binary:"pstring8"`
+	Fixed   [2]uint8 // not part of the layout
+	Wide    uint64   `bits:"64"`
+	Tail    []elem   `until:"eof"`
+}
+
+func TestGenerate(t *testing.T) {
+	g := &Generator{Rand: rand.New(rand.NewSource(1))}
+	for i := 0; i < 100; i++ {
+		var p packet
+		if err := g.Generate(&p); err != nil {
+			t.Fatal(err)
+		}
+		if int(p.N) != len(p.Elems) {
+			t.Errorf("want=%d elements, out: %d", p.N, len(p.Elems))
+		}
+		for _, e := range p.Elems {
+			if e.Type != 1 && e.Type != 2 && e.Type != 5 {
+				t.Errorf("want=enum value, out: %d", e.Type)
+			}
+			if e.Level != -4 && e.Level != -1 && e.Level != 3 {
+				t.Errorf("want=enum value, out: %d", e.Level)
+			}
+		}
+		if len(p.Host) > 6 || len(p.Raw) != 2 || len(p.Tail) > 8 {
+			t.Errorf("want=sizes in range, out: %#v", p)
+		}
+		AssertRoundTrip(t, &p)
+	}
+	if err := g.Generate(packet{}); err == nil {
+		t.Error("want=error for non-pointer, out: nil")
+	}
+}
+
+func TestGenerateDefaultRand(t *testing.T) {
+	var g Generator
+	var p, q packet
+	if err := g.Generate(&p); err != nil {
+		t.Fatal(err)
+	}
+	AssertRoundTrip(t, &p)
+	// A zero Generator is seeded the same way each time.
+	if err := new(Generator).Generate(&q); err != nil || !reflect.DeepEqual(p, q) {
+		t.Errorf("want=%#v, out: %#v, %v", p, q, err)
+	}
+}
+
+func TestGenerateChecksum(t *testing.T) {
+	type block struct {
+		V   uint16 `bits:"12"`
+		Sum uint8  `bits:"8" crc:"crc8"`
+	}
+	type frame struct {
+		Len     uint8   `bits:"4"`
+		N       uint8   `bits:"4"`
+		Payload []byte  `count:"Len"`
+		Blocks  []block `count:"N"`
+		Sum     uint32  `bits:"32" crc:"crc32"`
+	}
+	g := &Generator{Rand: rand.New(rand.NewSource(1))}
+	for i := 0; i < 100; i++ {
+		var f frame
+		if err := g.Generate(&f); err != nil {
+			t.Fatal(err)
+		}
+		AssertRoundTrip(t, &f)
+	}
+}
+
+func TestGenerateError(t *testing.T) {
+	var v struct {
+		N    uint8  `bits:"8"`
+		Name string `binary:"4" charset:"no-such-charset"`
+	}
+	err := new(Generator).Generate(&v)
+	var fe *bitstring.FieldError
+	if !errors.As(err, &fe) || fe.Field != "Name" || !errors.Is(err, bitstring.ErrUnknownCharset) {
+		t.Errorf("want=%v in field Name, out: %v", bitstring.ErrUnknownCharset, err)
+	}
+}
+
+func TestValues(t *testing.T) {
+	f := func(p packet, q *elem) bool {
+		return AssertRoundTrip(t, p) && AssertRoundTrip(t, q)
+	}
+	if err := quick.Check(f, &quick.Config{Values: Values(f)}); err != nil {
+		t.Error(err)
+	}
+}
+
+type mismatch struct {
+	A uint8 `bits:"4"`
+	B uint8 // not part of the layout
+}
+
+// recorder records errors instead of failing the test.
+type recorder struct {
+	testing.TB
+	errors int
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) { r.errors++ }
+
+func TestAssertRoundTrip(t *testing.T) {
+	r := &recorder{TB: t}
+	if AssertRoundTrip(r, mismatch{A: 1, B: 2}) || r.errors != 1 {
+		t.Errorf("want=1 error for a field outside the layout, out: %d", r.errors)
+	}
+}
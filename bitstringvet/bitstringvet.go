@@ -0,0 +1,313 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bitstringvet defines an Analyzer that checks the bits, binary,
+// count, until, enum and crc tags of struct fields decoded by the bitstring
+// package.
+//
+// It reports at compile time the tag errors that bitstring.Layout and
+// Decoder.Unmarshal would otherwise only report at run time: bit widths too
+// large for their field, tags on field types that cannot be decoded,
+// unknown tag options, and count and crc tags naming no preceding field.
+package bitstringvet
+
+import (
+	"go/ast"
+	"go/types"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+)
+
+const Doc = `check struct tags used by the bitstring decoder
+
+The bitstringvet analyzer reports struct fields whose bits, binary, count,
+until, enum or crc tags cannot be decoded by bitstring.Unmarshal.`
+
+var Analyzer = &analysis.Analyzer{
+	Name:     "bitstringvet",
+	Doc:      Doc,
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      run,
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	inspect := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+
+	nodeFilter := []ast.Node{
+		(*ast.StructType)(nil),
+	}
+	inspect.Preorder(nodeFilter, func(n ast.Node) {
+		styp, ok := pass.TypesInfo.Types[n.(*ast.StructType)].Type.(*types.Struct)
+		if !ok {
+			return
+		}
+		checkStruct(pass, n.(*ast.StructType), styp)
+	})
+	return nil, nil
+}
+
+// checkStruct checks the tags of each field of st, whose type is styp.
+func checkStruct(pass *analysis.Pass, st *ast.StructType, styp *types.Struct) {
+	// uints holds the names of tagged uint fields seen so far, which can be
+	// referred to by count tags of following fields, and names those of all
+	// tagged fields, which can be covered by crc tags.
+	uints := make(map[string]bool)
+	names := make(map[string]bool)
+	i := 0
+	for _, field := range st.Fields.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1 // embedded field
+		}
+		for j := 0; j < n; j++ {
+			v := styp.Field(i)
+			tag := reflect.StructTag(styp.Tag(i))
+			i++
+			if !isTagged(tag) {
+				continue
+			}
+			msg := checkField(v.Type(), tag, uints)
+			if crc, ok := tag.Lookup("crc"); ok && len(msg) == 0 {
+				msg = checkCRC(v.Type(), crc, names)
+			}
+			if len(msg) != 0 {
+				pass.Reportf(field.Pos(), "field %s: %s", v.Name(), msg)
+				continue
+			}
+			if isUint(v.Type()) {
+				uints[v.Name()] = true
+			}
+			if v.Name() != "_" {
+				names[v.Name()] = true
+			}
+		}
+	}
+}
+
+func isTagged(tag reflect.StructTag) bool {
+	for _, key := range []string{"bits", "binary", "count", "until"} {
+		if _, ok := tag.Lookup(key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkField returns a description of the problem with the tags of a field
+// of type typ, or an empty string if it can be decoded.
+func checkField(typ types.Type, tag reflect.StructTag, uints map[string]bool) string {
+	count, hasCount := tag.Lookup("count")
+	until, hasUntil := tag.Lookup("until")
+	if hasCount || hasUntil {
+		sl, ok := typ.Underlying().(*types.Slice)
+		if !ok {
+			return "count and until tags require a slice of bytes or structs"
+		}
+		if _, ok := sl.Elem().Underlying().(*types.Struct); !ok && !isByte(sl.Elem()) {
+			return "count and until tags require a slice of bytes or structs, not " + typ.String()
+		}
+		if hasUntil {
+			if until != "eof" {
+				return `unknown until option "` + until + `"`
+			}
+			return ""
+		}
+		if _, err := strconv.ParseUint(count, 0, 64); err != nil && !uints[count] {
+			return `count tag "` + count + `" is neither a number nor a preceding uint field`
+		}
+		return ""
+	}
+
+	if bits, ok := tag.Lookup("bits"); ok {
+		size, err := strconv.ParseUint(bits, 0, 64)
+		if err != nil {
+			return `invalid bit size "` + bits + `"`
+		}
+		switch {
+		case isUint(typ), isInt(typ):
+			if size > uintBits(typ)+intBits(typ) {
+				return "bit size " + bits + " is too large for " + typ.String()
+			}
+			if enum, ok := tag.Lookup("enum"); ok {
+				return checkEnum(enum, isInt(typ))
+			}
+		case isBool(typ):
+			if size > 64 {
+				return "bit size " + bits + " is too large for " + typ.String()
+			}
+		case isByteSlice(typ):
+		default:
+			return "bits tag is not supported for " + typ.String()
+		}
+		return ""
+	}
+
+	binary := tag.Get("binary")
+	isString := isString(typ)
+	if !isString && !isByteSlice(typ) {
+		return "binary tag is not supported for " + typ.String()
+	}
+	var sized bool
+	var mode string
+	for _, s := range strings.Split(binary, ",") {
+		s = strings.TrimSpace(s)
+		if _, err := strconv.ParseUint(s, 0, 64); err == nil {
+			if sized {
+				return `duplicate size in binary tag "` + binary + `"`
+			}
+			sized = true
+			continue
+		}
+		switch s {
+		case "cstring", "zstring", "pstring8", "pstring16", "pstring32":
+			if !isString {
+				return "string mode " + s + " is not supported for " + typ.String()
+			}
+			if len(mode) != 0 {
+				return `duplicate string mode in binary tag "` + binary + `"`
+			}
+			mode = s
+		default:
+			return `unknown binary option "` + s + `"`
+		}
+	}
+	switch mode {
+	case "", "cstring":
+		if !sized {
+			return `binary tag "` + binary + `" requires a byte size`
+		}
+	default:
+		if sized {
+			return "string mode " + mode + " does not take a byte size"
+		}
+	}
+	return ""
+}
+
+// checkEnum checks the syntax of an enum tag, whose values are signed
+// integers if signed is set.
+func checkEnum(enum string, signed bool) string {
+	for _, pair := range strings.Split(enum, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return `invalid enum pair "` + pair + `"`
+		}
+		var err error
+		if signed {
+			_, err = strconv.ParseInt(kv[0], 0, 64)
+		} else {
+			_, err = strconv.ParseUint(kv[0], 0, 64)
+		}
+		if err != nil {
+			return `invalid enum value "` + kv[0] + `"`
+		}
+	}
+	return ""
+}
+
+// checkCRC checks a crc tag on a field of type typ. The CRC name is not
+// checked, since CRCs can be registered at run time.
+func checkCRC(typ types.Type, crc string, names map[string]bool) string {
+	if !isUint(typ) {
+		return "crc tag is not supported for " + typ.String()
+	}
+	if len(names) == 0 {
+		return "crc tag requires preceding fields to cover"
+	}
+	for _, opt := range strings.Split(crc, ",")[1:] {
+		kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+		if len(kv) != 2 || kv[0] != "from" && kv[0] != "to" {
+			return `unknown crc option "` + opt + `"`
+		}
+		if !names[kv[1]] {
+			return "crc " + kv[0] + ` field "` + kv[1] + `" is not a preceding field`
+		}
+	}
+	return ""
+}
+
+func isUint(typ types.Type) bool {
+	return uintBits(typ) > 0
+}
+
+// uintBits returns the bit size of a fixed size uint type, or 0 for other
+// types.
+func uintBits(typ types.Type) uint64 {
+	b, ok := typ.Underlying().(*types.Basic)
+	if !ok {
+		return 0
+	}
+	switch b.Kind() {
+	case types.Uint8:
+		return 8
+	case types.Uint16:
+		return 16
+	case types.Uint32:
+		return 32
+	case types.Uint64:
+		return 64
+	}
+	return 0
+}
+
+func isInt(typ types.Type) bool {
+	return intBits(typ) > 0
+}
+
+// intBits returns the bit size of a fixed size signed integer type, or 0
+// for other types.
+func intBits(typ types.Type) uint64 {
+	b, ok := typ.Underlying().(*types.Basic)
+	if !ok {
+		return 0
+	}
+	switch b.Kind() {
+	case types.Int8:
+		return 8
+	case types.Int16:
+		return 16
+	case types.Int32:
+		return 32
+	case types.Int64:
+		return 64
+	}
+	return 0
+}
+
+func isString(typ types.Type) bool {
+	b, ok := typ.Underlying().(*types.Basic)
+	return ok && b.Kind() == types.String
+}
+
+func isBool(typ types.Type) bool {
+	b, ok := typ.Underlying().(*types.Basic)
+	return ok && b.Kind() == types.Bool
+}
+
+func isByte(typ types.Type) bool {
+	b, ok := typ.Underlying().(*types.Basic)
+	return ok && b.Kind() == types.Uint8
+}
+
+func isByteSlice(typ types.Type) bool {
+	sl, ok := typ.Underlying().(*types.Slice)
+	return ok && isByte(sl.Elem())
+}
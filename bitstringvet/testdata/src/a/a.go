@@ -0,0 +1,47 @@
+package a
+
+type State uint8
+
+type Level int8
+
+type Good struct {
+	Flag  bool   `bits:"1"`
+	State State  `bits:"3" enum:"0=Idle,1=Run"`
+	Level Level  `bits:"4" enum:"-1=Low,0=Zero,1=High"`
+	Len   uint16 `bits:"12"`
+	Data  []byte `count:"Len"`
+	Name  string `binary:"8,cstring"`
+	Note  string `binary:"pstring8"`
+	Elems []Elem `until:"eof"`
+	_     uint8  `bits:"4"`
+	Sum   uint16 `bits:"16" crc:"crc16-ccitt,from=Flag,to=Elems"`
+	Other int
+}
+
+type Elem struct {
+	N uint8 `bits:"8"`
+}
+
+type Bad struct {
+	F1  uint8  `bits:"9"`              // want `field F1: bit size 9 is too large for uint8`
+	F2  int    `bits:"8"`              // want `field F2: bits tag is not supported for int`
+	F3  uint8  `binary:"1"`            // want `field F3: binary tag is not supported for uint8`
+	F4  string `binary:"8,utf8"`       // want `field F4: unknown binary option "utf8"`
+	F5  []byte `binary:"zstring"`      // want `field F5: string mode zstring is not supported for \[\]byte`
+	F6  []byte `count:"Length"`        // want `field F6: count tag "Length" is neither a number nor a preceding uint field`
+	F7  []Elem `until:"end"`           // want `field F7: unknown until option "end"`
+	F8  uint8  `bits:"x"`              // want `field F8: invalid bit size "x"`
+	F9  State  `bits:"2" enum:"Idle"`  // want `field F9: invalid enum pair "Idle"`
+	F10 string `binary:"zstring,4"`    // want `field F10: string mode zstring does not take a byte size`
+	F11 Level  `bits:"4" enum:"x=Low"` // want `field F11: invalid enum value "x"`
+	Hdr uint8  `bits:"8"`
+	F12 bool   `bits:"8" crc:"crc8"`         // want `field F12: crc tag is not supported for bool`
+	F13 uint8  `bits:"8" crc:"crc8,over=F1"` // want `field F13: unknown crc option "over=F1"`
+	F14 uint8  `bits:"8" crc:"crc8,to=F15"`  // want `field F14: crc to field "F15" is not a preceding field`
+	F15 uint8  `bits:"8"`
+}
+
+type BadCRC struct {
+	Sum uint8 `bits:"8" crc:"crc8"` // want `field Sum: crc tag requires preceding fields to cover`
+	A   uint8 `bits:"8"`
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package charset registers character encodings beyond Latin-1 for the
+// charset tag of string fields. It is imported for its side effects:
+//
+//	import _ "github.com/ymotongpoo/go-bitstring/charset"
+//
+// after which a field can be declared as
+//
+//	Name string `binary:"32,cstring" charset:"shift_jis"`
+package charset
+
+import (
+	bitstring "github.com/ymotongpoo/go-bitstring"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+)
+
+func init() {
+	register(japanese.ShiftJIS, "shift_jis", "sjis", "cp932")
+	register(japanese.EUCJP, "euc-jp")
+	register(japanese.ISO2022JP, "iso-2022-jp")
+}
+
+func register(enc encoding.Encoding, names ...string) {
+	dec := func(data []byte) (string, error) {
+		b, err := enc.NewDecoder().Bytes(data)
+		return string(b), err
+	}
+	encode := func(s string) ([]byte, error) {
+		return enc.NewEncoder().Bytes([]byte(s))
+	}
+	for _, name := range names {
+		bitstring.RegisterCharset(name, dec)
+		bitstring.RegisterCharsetEncoder(name, encode)
+	}
+}
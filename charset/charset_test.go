@@ -0,0 +1,46 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package charset
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	bitstring "github.com/ymotongpoo/go-bitstring"
+)
+
+func TestShiftJIS(t *testing.T) {
+	type S struct {
+		Name string `binary:"8,cstring" charset:"shift_jis"`
+	}
+
+	var data = []byte{
+		0x82, 0xa0, // HIRAGANA LETTER A
+		0x82, 0xa2, // HIRAGANA LETTER I
+		0x00, 0x00, 0x00, 0x00,
+	}
+
+	b := bitstring.NewBuffer(bytes.NewBuffer(data))
+	out := &S{}
+	err := bitstring.Unmarshal(b, out)
+	if err != nil && err != io.EOF {
+		t.Error(err)
+	}
+	if out.Name != "あい" {
+		t.Errorf("want=%q, out: %q", "あい", out.Name)
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCRCTag is returned for a crc tag that is not on a uint field of
+// the width of the CRC, or that does not cover preceding fields.
+var ErrInvalidCRCTag = errors.New("bitarray: crc tag must name a CRC and preceding fields, on a uint field of its width")
+
+// A CRCField describes the CRC held by a checksum field, computed over the
+// raw bits of the fields from From to To, which precede it in the same
+// struct. In a struct, it is given by a tag such as
+//
+//	Sum uint16 `bits:"16" crc:"crc16-ccitt,from=Header,to=Payload"`
+//
+// where from defaults to the first field and to to the field before the
+// checksum field. With RefIn parameters, the bits covered are taken a byte at
+// a time from the first one, each byte least significant bit first, so that
+// fields filling whole bytes get the standard CRC of those bytes.
+type CRCField struct {
+	Name   string    // name the CRC parameters are registered as
+	Params CRCParams // parameters registered as Name
+	From   string    // name of the first field covered
+	To     string    // name of the last field covered
+
+	from, to int // positions of From and To in the fields of the layout
+}
+
+// A ChecksumError reports a checksum field whose value is not the CRC of the
+// fields it covers.
+type ChecksumError struct {
+	Field string // name of the checksum field
+	Want  uint64 // CRC of the fields covered
+	Value uint64 // decoded value
+}
+
+func (e *ChecksumError) Error() string {
+	return "bitarray: checksum " + e.Field + " is 0x" + strconv.FormatUint(e.Value, 16) +
+		", want 0x" + strconv.FormatUint(e.Want, 16)
+}
+
+// parseCRCTag parses a crc tag: a CRC name followed by optional from and
+// to options.
+func parseCRCTag(tag string) (*CRCField, error) {
+	parts := strings.Split(tag, ",")
+	c := &CRCField{Name: strings.TrimSpace(parts[0])}
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrInvalidCRCTag
+		}
+		switch kv[0] {
+		case "from":
+			c.From = kv[1]
+		case "to":
+			c.To = kv[1]
+		default:
+			return nil, ErrInvalidCRCTag
+		}
+	}
+	return c, nil
+}
+
+// resolveCRC looks up the parameters of the CRC of f, the next field of l,
+// and the fields it covers.
+func (l *StructLayout) resolveCRC(f *FieldLayout) error {
+	c := f.CRC
+	if c == nil {
+		return nil
+	}
+	if f.Kind != UintKind || f.Signed || len(l.Fields) == 0 {
+		return ErrInvalidCRCTag
+	}
+	p, err := LookupCRC(c.Name)
+	if err != nil {
+		return err
+	}
+	if _, err := NewCRC(p); err != nil {
+		return err
+	}
+	if p.Width != f.Bits {
+		return ErrInvalidCRCTag
+	}
+	c.from, c.to = 0, len(l.Fields)-1
+	for i := range l.Fields {
+		name := l.Fields[i].Name
+		if name == "_" {
+			continue
+		}
+		if name == c.From {
+			c.from = i
+		}
+		if name == c.To {
+			c.to = i
+		}
+	}
+	if c.From != "" && l.Fields[c.from].Name != c.From ||
+		c.To != "" && l.Fields[c.to].Name != c.To || c.from > c.to {
+		return ErrInvalidCRCTag
+	}
+	c.From, c.To = l.Fields[c.from].Name, l.Fields[c.to].Name
+	c.Params = p
+	return nil
+}
+
+// A crcHost feeds the bits it pops or pushes to running CRCs.
+type crcHost interface {
+	StartCRC(c *CRC)
+	stopCRC(c *CRC)
+}
+
+// checksums computes the CRCs of the checksum fields of a record while it
+// is decoded or encoded.
+type checksums struct {
+	l    *StructLayout
+	host crcHost
+	crcs map[int]*CRC // CRCs by position of their checksum field
+}
+
+func newChecksums(l *StructLayout, host crcHost) *checksums {
+	if !l.checksums {
+		return nil
+	}
+	return &checksums{l: l, host: host, crcs: make(map[int]*CRC)}
+}
+
+// begin starts the CRCs that cover the i-th field onwards.
+func (s *checksums) begin(i int) {
+	if s == nil {
+		return
+	}
+	for j := i + 1; j < len(s.l.Fields); j++ {
+		if c := s.l.Fields[j].CRC; c != nil && c.from == i {
+			crc, _ := NewCRC(c.Params)
+			crc.bytewise = c.Params.RefIn
+			s.host.StartCRC(crc)
+			s.crcs[j] = crc
+		}
+	}
+}
+
+// end stops the CRCs that cover fields up to the i-th.
+func (s *checksums) end(i int) {
+	if s == nil {
+		return
+	}
+	for j := i + 1; j < len(s.l.Fields); j++ {
+		if c := s.l.Fields[j].CRC; c != nil && c.to == i {
+			s.host.stopCRC(s.crcs[j])
+		}
+	}
+}
+
+// sum returns the CRC held by the i-th field.
+func (s *checksums) sum(i int) uint64 {
+	return s.crcs[i].Sum64()
+}
+
+// stop stops the CRCs still running, after an error.
+func (s *checksums) stop() {
+	if s == nil {
+		return
+	}
+	for _, crc := range s.crcs {
+		s.host.stopCRC(crc)
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type crcFrame struct {
+	Header  uint8  `bits:"4"`
+	Len     uint8  `bits:"4"`
+	Payload []byte `count:"Len"`
+	Sum     uint16 `bits:"16" crc:"crc16-ccitt,from=Header,to=Payload"`
+}
+
+func TestChecksum(t *testing.T) {
+	in := crcFrame{Header: 0x3, Len: 9, Payload: []byte("123456789")}
+	data, err := Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := LookupCRC("crc16-ccitt")
+	c, _ := NewCRC(p)
+	c.Write(data[:10])
+	if want := uint16(c.Sum64()); data[10] != byte(want>>8) || data[11] != byte(want) {
+		t.Errorf("want=%#x, out: %#x", want, data[10:])
+	}
+
+	var out crcFrame
+	if err := Unmarshal(NewBuffer(bytes.NewBuffer(data)), &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Sum = out.Sum
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("want=%#v, out: %#v", in, out)
+	}
+
+	data[3] ^= 0x10
+	err = Unmarshal(NewBuffer(bytes.NewBuffer(data)), &out)
+	var sumErr *ChecksumError
+	if !errors.As(err, &sumErr) || sumErr.Field != "Sum" || sumErr.Value != uint64(in.Sum) {
+		t.Errorf("want *ChecksumError on Sum, out: %v", err)
+	}
+}
+
+func TestChecksumBits(t *testing.T) {
+	// A CAN data frame protects its unaligned fields with CRC-15.
+	type CANFrame struct {
+		SOF  uint8  `bits:"1"`
+		ID   uint16 `bits:"11"`
+		RTR  bool   `bits:"1"`
+		IDE  bool   `bits:"1"`
+		R0   bool   `bits:"1"`
+		DLC  uint8  `bits:"4"`
+		Data []byte `count:"DLC"`
+		CRC  uint16 `bits:"15" crc:"crc15-can"`
+		Del  uint8  `bits:"1"`
+	}
+	in := CANFrame{ID: 0x123, DLC: 2, Data: []byte{0xbe, 0xef}, Del: 1}
+	data, err := Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, _ := LookupCRC("crc15-can")
+	c, _ := NewCRC(p)
+	c.WriteBits(0, 1)
+	c.WriteBits(0x123, 11)
+	c.WriteBits(0, 3)
+	c.WriteBits(2, 4)
+	c.WriteBits(0xbeef, 16)
+	var out CANFrame
+	if err := Unmarshal(NewBuffer(bytes.NewBuffer(data)), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.CRC != uint16(c.Sum64()) || out.Del != 1 {
+		t.Errorf("want=%#x, out: %#v", c.Sum64(), out)
+	}
+}
+
+func TestChecksumOverlap(t *testing.T) {
+	type Elem struct {
+		V   uint8 `bits:"8"`
+		Sum uint8 `bits:"8" crc:"crc8"`
+	}
+	type S struct {
+		Ver       uint8  `bits:"4"`
+		N         uint8  `bits:"4"`
+		HeaderSum uint8  `bits:"8" crc:"crc8-maxim"`
+		Elems     []Elem `count:"N"`
+		FrameSum  uint32 `bits:"32" crc:"crc32,from=N"`
+	}
+	in := S{Ver: 1, N: 2, Elems: []Elem{{V: 1}, {V: 2}}}
+	data, err := Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out S
+	if err := Unmarshal(NewBuffer(bytes.NewBuffer(data)), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.HeaderSum == 0 || out.FrameSum == 0 || out.Elems[0].Sum == out.Elems[1].Sum {
+		t.Errorf("want checksums filled in, out: %#v", out)
+	}
+	data[3] ^= 0x01
+	err = Unmarshal(NewBuffer(bytes.NewBuffer(data)), &out)
+	var sumErr *ChecksumError
+	if !errors.As(err, &sumErr) || sumErr.Field != "Sum" {
+		t.Errorf("want *ChecksumError on Sum of the first element, out: %v", err)
+	}
+}
+
+func TestChecksumPresets(t *testing.T) {
+	// Each preset over "123456789" must give its Check value, reflected
+	// presets included.
+	for name, p := range crcs {
+		typ := reflect.StructOf([]reflect.StructField{
+			{Name: "Payload", Type: reflect.TypeOf([]byte(nil)), Tag: `binary:"9"`},
+			{Name: "Sum", Type: reflect.TypeOf(uint64(0)),
+				Tag: reflect.StructTag(`bits:"` + strconv.Itoa(p.Width) + `" crc:"` + name + `"`)},
+		})
+		in := reflect.New(typ)
+		in.Elem().Field(0).SetBytes([]byte("123456789"))
+		data, err := Marshal(in.Interface())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var want bytes.Buffer
+		w := NewWriter(&want)
+		w.PushBytes([]byte("123456789"))
+		for n := p.Width; n > 0; n -= 8 {
+			size := min(n, 8)
+			w.PushUint8(uint8(p.Check>>uint(n-size)), uint64(size))
+		}
+		w.Flush()
+		if !bytes.Equal(data, want.Bytes()) {
+			t.Errorf("%s: want=%#v, out: %#v", name, want.Bytes(), data)
+		}
+		out := reflect.New(typ)
+		if err := Unmarshal(NewBuffer(bytes.NewBuffer(want.Bytes())), out.Interface()); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestChecksumHashCRC32(t *testing.T) {
+	type Frame struct {
+		Payload    []byte `binary:"13"`
+		IEEE       uint32 `bits:"32" crc:"crc32,to=Payload"`
+		Castagnoli uint32 `bits:"32" crc:"crc32c,to=Payload"`
+	}
+	in := Frame{Payload: []byte("hello, world!")}
+	data, err := Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Frame
+	if err := Unmarshal(NewBuffer(bytes.NewBuffer(data)), &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := crc32.ChecksumIEEE(in.Payload); out.IEEE != want {
+		t.Errorf("want=%#x, out: %#x", want, out.IEEE)
+	}
+	if want := crc32.Checksum(in.Payload, crc32.MakeTable(crc32.Castagnoli)); out.Castagnoli != want {
+		t.Errorf("want=%#x, out: %#x", want, out.Castagnoli)
+	}
+}
+
+func TestChecksumLayoutError(t *testing.T) {
+	cases := []struct {
+		v   interface{}
+		err error
+	}{
+		{struct {
+			A  uint8  `bits:"8"`
+			F1 uint16 `bits:"16" crc:"crc17"`
+		}{}, ErrUnknownCRC},
+		{struct {
+			A  uint8 `bits:"8"`
+			F1 uint8 `bits:"7" crc:"crc8"`
+		}{}, ErrInvalidCRCTag},
+		{struct {
+			F1 uint8 `bits:"8" crc:"crc8"`
+		}{}, ErrInvalidCRCTag},
+		{struct {
+			A  uint8 `bits:"8"`
+			F1 uint8 `bits:"8" crc:"crc8,to=B"`
+			B  uint8 `bits:"8"`
+		}{}, ErrInvalidCRCTag},
+		{struct {
+			A  uint8 `bits:"8"`
+			B  uint8 `bits:"8"`
+			F1 uint8 `bits:"8" crc:"crc8,from=B,to=A"`
+		}{}, ErrInvalidCRCTag},
+		{struct {
+			A  uint8 `bits:"8"`
+			F1 bool  `bits:"8" crc:"crc8"`
+		}{}, ErrInvalidCRCTag},
+		{struct {
+			A  uint8 `bits:"8"`
+			F1 uint8 `bits:"8" crc:"crc8,over=A"`
+		}{}, ErrInvalidCRCTag},
+	}
+	for i, c := range cases {
+		_, err := Layout(reflect.TypeOf(c.v))
+		var fieldErr *FieldError
+		if !errors.As(err, &fieldErr) || fieldErr.Field != "F1" || !errors.Is(err, c.err) {
+			t.Errorf("%d: want=%v on F1, out: %v", i, c.err, err)
+		}
+	}
+}
+
+func TestChecksumMap(t *testing.T) {
+	l, err := NewLayout("frame", []FieldLayout{
+		{Name: "A", Kind: UintKind, Bits: 12},
+		{Name: "B", Kind: UintKind, Bits: 12},
+		{Name: "Sum", Kind: UintKind, Bits: 8, CRC: &CRCField{Name: "crc8", To: "A"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := l.Fields[2].CRC; f.From != "A" || f.To != "A" || f.Params.Width != 8 {
+		t.Errorf("want CRC over A, out: %+v", f)
+	}
+
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	if err := NewEncoder(w).EncodeMap(l, map[string]interface{}{"A": 0xabc, "B": 0x123}); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	m, err := NewDecoder(NewBuffer(bytes.NewBuffer(out.Bytes()))).DecodeMap(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := LookupCRC("crc8")
+	c, _ := NewCRC(p)
+	c.WriteBits(0xabc, 12)
+	if m["Sum"] != c.Sum64() {
+		t.Errorf("want=%#x, out: %#v", c.Sum64(), m)
+	}
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The bitstring command extracts and inspects bits of binary files. It reads
+// the named file, or standard input if none is given.
+//
+//	bitstring get --offset 13 --bits 22 file.bin
+//	bitstring dump --schema ipv4.yaml packet.bin
+//	bitstring slice --offset 13 --bits 22 -o out.bin file.bin
+//
+// get prints the value of a bit range, dump prints the fields decoded with
+// a schema (see package schema) and slice writes a bit range to a new file,
+// padded with zero bits to a whole byte.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	bitstring "github.com/ymotongpoo/go-bitstring"
+	"github.com/ymotongpoo/go-bitstring/schema"
+)
+
+const usage = `usage:
+	bitstring get --offset N --bits N [--format dec|hex|bin] [file]
+	bitstring dump --schema file [--json] [file]
+	bitstring slice --offset N --bits N [-o file] [file]
+`
+
+var (
+	errUsage = errors.New("invalid arguments")
+	errBits  = errors.New("--bits must be 1..64")
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if err == errBits {
+			fmt.Fprintln(os.Stderr, "bitstring:", err)
+		}
+		if err == errUsage || err == errBits || err == flag.ErrHelp {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "bitstring:", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command given by args.
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	offset := fs.Uint64("offset", 0, "bit offset of the range")
+	bits := fs.Uint64("bits", 0, "bit width of the range")
+	switch args[0] {
+	case "get":
+		format := fs.String("format", "dec", "output format: dec, hex or bin")
+		in, err := parse(fs, args[1:], stdin)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		return get(bitstring.NewBuffer(bufio.NewReader(in)), *offset, *bits, *format, stdout)
+	case "dump":
+		schemaFile := fs.String("schema", "", "YAML schema of the data")
+		asJSON := fs.Bool("json", false, "print the decoded value tree as JSON")
+		in, err := parse(fs, args[1:], stdin)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		if len(*schemaFile) == 0 {
+			return errUsage
+		}
+		return dump(in, *schemaFile, *asJSON, stdout)
+	case "slice":
+		out := fs.String("o", "", "output file; standard output if empty")
+		in, err := parse(fs, args[1:], stdin)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		if len(*out) == 0 {
+			return writeSlice(in, *offset, *bits, stdout)
+		}
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		if err := writeSlice(in, *offset, *bits, f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	return errUsage
+}
+
+// writeSlice writes bits bits at offset of in to w.
+func writeSlice(in io.Reader, offset, bits uint64, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if err := slice(bitstring.NewBuffer(bufio.NewReader(in)), offset, bits, bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// parse parses the flags of a command and opens its input.
+func parse(fs *flag.FlagSet, args []string, stdin io.Reader) (io.ReadCloser, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	switch fs.NArg() {
+	case 0:
+		return io.NopCloser(stdin), nil
+	case 1:
+		return os.Open(fs.Arg(0))
+	}
+	return nil, errUsage
+}
+
+// skip pops n bits from b. It stops at the end of b, which is an
+// io.ErrUnexpectedEOF if bits are left to skip.
+func skip(b *bitstring.Buffer, n uint64) error {
+	for n > 0 {
+		size := n
+		if size > bitstring.Uint64Size {
+			size = bitstring.Uint64Size
+		}
+		_, err := b.PopUint64(size)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		n -= size
+		if b.Truncated() || err == io.EOF && n > 0 {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	return nil
+}
+
+// get prints the bits-bit value at offset of b.
+func get(b *bitstring.Buffer, offset, bits uint64, format string, w io.Writer) error {
+	if bits == 0 || bits > bitstring.Uint64Size {
+		return errBits
+	}
+	if err := skip(b, offset); err != nil {
+		return err
+	}
+	v, err := b.PopUint64(bits)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if b.Truncated() {
+		return io.ErrUnexpectedEOF
+	}
+	switch format {
+	case "dec":
+		_, err = fmt.Fprintln(w, v)
+	case "hex":
+		_, err = fmt.Fprintf(w, "%#x\n", v)
+	case "bin":
+		_, err = fmt.Fprintf(w, "%0*s\n", bits, strconv.FormatUint(v, 2))
+	default:
+		return errUsage
+	}
+	return err
+}
+
+// slice writes bits bits at offset of b to w.
+func slice(b *bitstring.Buffer, offset, bits uint64, w io.ByteWriter) error {
+	if err := skip(b, offset); err != nil {
+		return err
+	}
+	out := bitstring.NewWriter(w)
+	for bits > 0 {
+		size := bits
+		if size > bitstring.Uint64Size {
+			size = bitstring.Uint64Size
+		}
+		v, err := b.PopUint64(size)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if b.Truncated() {
+			return io.ErrUnexpectedEOF
+		}
+		if err := out.PushUint64(v, size); err != nil {
+			return err
+		}
+		bits -= size
+	}
+	return out.Flush()
+}
+
+// dump prints the data of in decoded with the schema in schemaFile.
+func dump(in io.Reader, schemaFile string, asJSON bool, w io.Writer) error {
+	l, err := schema.Load(schemaFile)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	if !asJSON {
+		return bitstring.Dump(w, l, data)
+	}
+	b := bitstring.NewBuffer(bytes.NewReader(data))
+	v, err := bitstring.NewDecoder(b).DecodeValue(l)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
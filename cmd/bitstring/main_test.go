@@ -0,0 +1,114 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	// |1010101|1,11001101,1111|0000
+	in := []byte{0xab, 0xcd, 0xf0}
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"get", "--offset", "7", "--bits", "13"}, "7391\n"},
+		{[]string{"get", "--offset", "7", "--bits", "13", "--format", "hex"}, "0x1cdf\n"},
+		{[]string{"get", "--offset", "0", "--bits", "4", "--format", "bin"}, "1010\n"},
+		{[]string{"get", "--offset", "20", "--bits", "4"}, "0\n"},
+	}
+	for _, c := range cases {
+		out := &bytes.Buffer{}
+		if err := run(c.args, bytes.NewReader(in), out); err != nil {
+			t.Errorf("%v: %v", c.args, err)
+			continue
+		}
+		if out.String() != c.want {
+			t.Errorf("%v: want=%q, out: %q", c.args, c.want, out.String())
+		}
+	}
+
+	for _, offset := range []string{"20", "24", "100", "100000000000000"} {
+		err := run([]string{"get", "--offset", offset, "--bits", "5"}, bytes.NewReader(in), io.Discard)
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("offset %s: want=%v, out: %v", offset, io.ErrUnexpectedEOF, err)
+		}
+	}
+	for _, args := range [][]string{
+		{"get", "--bits", "65"},
+		{"get", "--bits", "0"},
+		{"get", "--offset", "4"},
+	} {
+		if err := run(args, bytes.NewReader(in), io.Discard); err != errBits {
+			t.Errorf("%v: want=%v, out: %v", args, errBits, err)
+		}
+	}
+	if err := run([]string{"put"}, bytes.NewReader(in), io.Discard); err != errUsage {
+		t.Errorf("want=%v, out: %v", errUsage, err)
+	}
+}
+
+func TestSlice(t *testing.T) {
+	in := filepath.Join(t.TempDir(), "in.bin")
+	if err := os.WriteFile(in, []byte{0xab, 0xcd, 0xf0, 0x0f, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "out.bin")
+	if err := run([]string{"slice", "--offset", "4", "--bits", "70", "-o", out, in}, nil, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0xbc, 0xdf, 0x00, 0xf1, 0x23, 0x45, 0x67, 0x89, 0xa8}
+	if !bytes.Equal(want, data) {
+		t.Errorf("want=%x, out: %x", want, data)
+	}
+	err = run([]string{"slice", "--offset", "100000000000000", "--bits", "8", "-o", out, in}, nil, io.Discard)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("want=%v, out: %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestDump(t *testing.T) {
+	schema := filepath.Join(t.TempDir(), "s.yaml")
+	err := os.WriteFile(schema, []byte("fields: [{name: a, type: uint, bits: 4}, {name: b, type: uint, bits: 4}]"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err := run([]string{"dump", "--schema", schema}, bytes.NewReader([]byte{0x12}), out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "0000.4  12   ....0010  b: 2 (0x2)") {
+		t.Errorf("want=field b, out: %s", out)
+	}
+
+	out.Reset()
+	if err := run([]string{"dump", "--schema", schema, "--json"}, bytes.NewReader([]byte{0x12}), out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"name": "b"`) {
+		t.Errorf("want=JSON with field b, out: %s", out)
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"errors"
+	"math/bits"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrInvalidCRC = errors.New("bitarray: CRC width must be 1 to 64 bits and values must fit in it")
+	ErrUnknownCRC = errors.New("bitarray: unknown CRC")
+)
+
+// CRCParams are the parameters of a cyclic redundancy check in the model of
+// Ross Williams, as catalogued by reveng.
+type CRCParams struct {
+	Width  int    // width in bits, 1 to 64.
+	Poly   uint64 // generator polynomial without its top term, most significant bit first.
+	Init   uint64 // initial value of the register.
+	RefIn  bool   // bits of each byte written are taken least significant first.
+	RefOut bool   // the register is reversed before XorOut.
+	XorOut uint64 // value xored to the result.
+	Check  uint64 // CRC of the ASCII "123456789", to verify the parameters.
+}
+
+var (
+	crcMu sync.RWMutex
+	crcs  = map[string]CRCParams{
+		"crc5-usb":     {Width: 5, Poly: 0x05, Init: 0x1f, RefIn: true, RefOut: true, XorOut: 0x1f, Check: 0x19},
+		"crc8":         {Width: 8, Poly: 0x07, Check: 0xf4},
+		"crc8-maxim":   {Width: 8, Poly: 0x31, RefIn: true, RefOut: true, Check: 0xa1},
+		"crc15-can":    {Width: 15, Poly: 0x4599, Check: 0x059e},
+		"crc16-ccitt":  {Width: 16, Poly: 0x1021, Init: 0xffff, Check: 0x29b1},
+		"crc16-kermit": {Width: 16, Poly: 0x1021, RefIn: true, RefOut: true, Check: 0x2189},
+		"crc16-xmodem": {Width: 16, Poly: 0x1021, Check: 0x31c3},
+		"crc16-arc":    {Width: 16, Poly: 0x8005, RefIn: true, RefOut: true, Check: 0xbb3d},
+		"crc16-modbus": {Width: 16, Poly: 0x8005, Init: 0xffff, RefIn: true, RefOut: true, Check: 0x4b37},
+		"crc24-ble":    {Width: 24, Poly: 0x00065b, Init: 0x555555, RefIn: true, RefOut: true, Check: 0xc25a56},
+		"crc32": {Width: 32, Poly: 0x04c11db7, Init: 0xffffffff, RefIn: true, RefOut: true,
+			XorOut: 0xffffffff, Check: 0xcbf43926},
+		"crc32c": {Width: 32, Poly: 0x1edc6f41, Init: 0xffffffff, RefIn: true, RefOut: true,
+			XorOut: 0xffffffff, Check: 0xe3069283},
+		"crc64-xz": {Width: 64, Poly: 0x42f0e1eba9ea3693, Init: ^uint64(0), RefIn: true, RefOut: true,
+			XorOut: ^uint64(0), Check: 0x995dc9bbdf1939fa},
+	}
+)
+
+// RegisterCRC makes CRC parameters available by name to LookupCRC. Names
+// are case insensitive. The presets are crc5-usb, crc8, crc8-maxim,
+// crc15-can, crc16-ccitt (CCITT-FALSE), crc16-kermit, crc16-xmodem,
+// crc16-arc, crc16-modbus, crc24-ble, crc32, crc32c and crc64-xz.
+func RegisterCRC(name string, p CRCParams) {
+	crcMu.Lock()
+	defer crcMu.Unlock()
+	crcs[strings.ToLower(name)] = p
+	layoutCache.Clear()
+}
+
+// LookupCRC returns the CRC parameters registered as name.
+func LookupCRC(name string) (CRCParams, error) {
+	crcMu.RLock()
+	p, ok := crcs[strings.ToLower(name)]
+	crcMu.RUnlock()
+	if !ok {
+		return CRCParams{}, ErrUnknownCRC
+	}
+	return p, nil
+}
+
+// A CRC computes a cyclic redundancy check over bits written to it.
+type CRC struct {
+	p   CRCParams
+	reg uint64 // register, most significant bit first.
+
+	// For checksum fields with RefIn, bits written are grouped into bytes
+	// and each byte is shifted in least significant bit first.
+	bytewise bool
+	pend     uint64 // bits of the byte being grouped, most significant first.
+	npend    int
+}
+
+// NewCRC returns a CRC with the parameters p.
+func NewCRC(p CRCParams) (*CRC, error) {
+	if p.Width < 1 || p.Width > 64 {
+		return nil, ErrInvalidCRC
+	}
+	if m := p.mask(); p.Poly&^m != 0 || p.Init&^m != 0 || p.XorOut&^m != 0 {
+		return nil, ErrInvalidCRC
+	}
+	c := &CRC{p: p}
+	c.Reset()
+	return c, nil
+}
+
+func (p CRCParams) mask() uint64 {
+	return ^uint64(0) >> uint(64-p.Width)
+}
+
+// Reset sets the CRC back to its state before any bits were written.
+func (c *CRC) Reset() {
+	c.reg = c.p.Init
+	c.pend, c.npend = 0, 0
+}
+
+// Write writes the bits of data, each byte most significant bit first, or
+// least significant first if RefIn is set. It never returns an error.
+func (c *CRC) Write(data []byte) (int, error) {
+	for _, byt := range data {
+		if c.p.RefIn {
+			byt = bits.Reverse8(byt)
+		}
+		c.WriteBits(uint64(byt), 8)
+	}
+	return len(data), nil
+}
+
+// WriteBits writes the lowest n bits of v, most significant first, in the
+// order they are sent. RefIn does not apply to them.
+func (c *CRC) WriteBits(v uint64, n int) {
+	if !c.bytewise {
+		c.shift(v, n)
+		return
+	}
+	for i := n - 1; i >= 0; i-- {
+		c.pend = c.pend<<1 | v>>uint(i)&1
+		if c.npend++; c.npend == 8 {
+			c.shift(uint64(bits.Reverse8(uint8(c.pend))), 8)
+			c.pend, c.npend = 0, 0
+		}
+	}
+}
+
+// shift shifts the lowest n bits of v into the register, most significant
+// first.
+func (c *CRC) shift(v uint64, n int) {
+	top := uint(c.p.Width - 1)
+	for i := n - 1; i >= 0; i-- {
+		bit := v>>uint(i)&1 ^ c.reg>>top&1
+		c.reg = c.reg << 1 & c.p.mask()
+		if bit != 0 {
+			c.reg ^= c.p.Poly
+		}
+	}
+}
+
+// Sum64 returns the CRC of the bits written so far.
+func (c *CRC) Sum64() uint64 {
+	reg := c.reg
+	if c.npend > 0 {
+		// Bits short of a byte are reflected among themselves.
+		t := *c
+		t.shift(bits.Reverse64(c.pend)>>uint(64-c.npend), c.npend)
+		reg = t.reg
+	}
+	if c.p.RefOut {
+		reg = bits.Reverse64(reg) >> uint(64-c.p.Width)
+	}
+	return reg ^ c.p.XorOut
+}
+
+// Width returns the number of bits of the CRC.
+func (c *CRC) Width() int {
+	return c.p.Width
+}
+
+// StartCRC starts feeding c all the bits popped from b, until EndCRC. Bits
+// are fed in the order they are popped, so RefIn does not apply to them.
+// Several CRCs may run at once.
+func (b *Buffer) StartCRC(c *CRC) {
+	b.crcs = append(b.crcs, c)
+}
+
+// EndCRC stops feeding bits to the CRC started last and returns its value.
+// It returns 0 if no CRC is running.
+func (b *Buffer) EndCRC() uint64 {
+	var c *CRC
+	b.crcs, c = endCRC(b.crcs, nil)
+	if c == nil {
+		return 0
+	}
+	return c.Sum64()
+}
+
+func (b *Buffer) stopCRC(c *CRC) {
+	b.crcs, _ = endCRC(b.crcs, c)
+}
+
+// StartCRC starts feeding c all the bits pushed to w, until EndCRC. Padding
+// bits written by Flush are not fed. Several CRCs may run at once.
+func (w *Writer) StartCRC(c *CRC) {
+	w.crcs = append(w.crcs, c)
+}
+
+// EndCRC stops feeding bits to the CRC started last and returns its value.
+// It returns 0 if no CRC is running.
+func (w *Writer) EndCRC() uint64 {
+	var c *CRC
+	w.crcs, c = endCRC(w.crcs, nil)
+	if c == nil {
+		return 0
+	}
+	return c.Sum64()
+}
+
+func (w *Writer) stopCRC(c *CRC) {
+	w.crcs, _ = endCRC(w.crcs, c)
+}
+
+// endCRC removes c, or the last CRC if c is nil, from crcs.
+func endCRC(crcs []*CRC, c *CRC) ([]*CRC, *CRC) {
+	for i := len(crcs) - 1; i >= 0; i-- {
+		if c == nil || crcs[i] == c {
+			c = crcs[i]
+			return append(crcs[:i:i], crcs[i+1:]...), c
+		}
+	}
+	return crcs, nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"math/bits"
+	"testing"
+)
+
+func TestCRCPresets(t *testing.T) {
+	for name := range crcs {
+		p, err := LookupCRC(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := NewCRC(p)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		c.Write([]byte("123456789"))
+		if out := c.Sum64(); out != p.Check {
+			t.Errorf("%s: want=%#x, out: %#x", name, p.Check, out)
+		}
+		c.Reset()
+		c.Write([]byte("123456789"))
+		if out := c.Sum64(); out != p.Check {
+			t.Errorf("%s after Reset: want=%#x, out: %#x", name, p.Check, out)
+		}
+	}
+	if _, err := LookupCRC("CRC15-CAN"); err != nil {
+		t.Errorf("want case insensitive names, out: %v", err)
+	}
+	if _, err := LookupCRC("crc7"); err != ErrUnknownCRC {
+		t.Errorf("want=%v, out: %v", ErrUnknownCRC, err)
+	}
+}
+
+func TestNewCRCError(t *testing.T) {
+	for _, p := range []CRCParams{
+		{Width: 0},
+		{Width: 65},
+		{Width: 5, Poly: 0x25},
+		{Width: 5, Poly: 0x05, Init: 0x20},
+	} {
+		if _, err := NewCRC(p); err != ErrInvalidCRC {
+			t.Errorf("%+v: want=%v, out: %v", p, ErrInvalidCRC, err)
+		}
+	}
+}
+
+func TestBufferCRC(t *testing.T) {
+	// "123456789" after 3 bits, popped in odd sizes.
+	var bld builder
+	bld.push(0x5, 3)
+	for _, c := range []byte("123456789") {
+		bld.push(uint64(c), 8)
+	}
+	bld.push(0x1, 1)
+	s := bld.bitString()
+
+	p, _ := LookupCRC("crc16-ccitt")
+	c, _ := NewCRC(p)
+	b := s.Reader()
+	b.PopUint8(3)
+	b.StartCRC(c)
+	for _, size := range []uint64{5, 13, 1, 32, 21} {
+		b.PopUint32(size)
+	}
+	if out := b.EndCRC(); out != p.Check {
+		t.Errorf("want=%#x, out: %#x", p.Check, out)
+	}
+	b.PopUint8(1)
+	if out := c.Sum64(); out != p.Check {
+		t.Errorf("want no bits after EndCRC, out: %#x", out)
+	}
+
+	// Reflected CRCs take the bits as popped, so send bytes reversed.
+	var data []byte
+	for _, c := range []byte("123456789") {
+		data = append(data, bits.Reverse8(c))
+	}
+	p, _ = LookupCRC("crc32")
+	c, _ = NewCRC(p)
+	b = NewBuffer(bytes.NewBuffer(data))
+	b.StartCRC(c)
+	b.PopBytes(9)
+	if out := b.EndCRC(); out != p.Check {
+		t.Errorf("want=%#x, out: %#x", p.Check, out)
+	}
+	if out := b.EndCRC(); out != 0 {
+		t.Errorf("want=0 with no CRC, out: %#x", out)
+	}
+}
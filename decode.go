@@ -18,26 +18,173 @@ package bitstring
 import (
 	"errors"
 	"io"
-	"log"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 var (
+	ErrNoProgress           = errors.New("bitarray: slice element consumes no bits")
 	ErrFieldSizeTooLarge    = errors.New("bitarray: Specified bit size is too large for field")
-	ErrUnsupportedFieldType = errors.New("bitarray: Field type must be uint/byte or slice of them")
+	ErrUnsupportedFieldType = errors.New("bitarray: Field type must be uint/int/byte/bool or slice of them")
+	ErrInvalidEnumTag       = errors.New("bitarray: enum tag must be a list of value=Name pairs")
+	ErrInvalidCountTag      = errors.New("bitarray: count tag must be a number or the name of a preceding uint field")
+	ErrInvalidUntilTag      = errors.New("bitarray: until tag must be \"eof\"")
 )
 
+// An EnumError describes a decoded value that is not a member of the value
+// set declared for its field.
+type EnumError struct {
+	Field string       // name of the struct field
+	Type  reflect.Type // type of the struct field, or nil for a runtime layout
+	Value uint64       // decoded value, as uint64(v) for signed types
+}
+
+func (e *EnumError) Error() string {
+	value := strconv.FormatUint(e.Value, 10)
+	if e.Type == nil {
+		return "bitarray: invalid value " + value + " for field " + e.Field
+	}
+	if isSigned(e.Type) {
+		value = strconv.FormatInt(int64(e.Value), 10)
+	}
+	return "bitarray: invalid value " + value + " for field " + e.Field + " of type " + e.Type.String()
+}
+
+var (
+	enumMu sync.RWMutex
+	enums  = make(map[reflect.Type]map[uint64]string)
+)
+
+// An EnumTagError describes a pair of an enum tag that is not value=Name,
+// or whose value does not fit in its field.
+type EnumTagError struct {
+	Pair string // the invalid pair
+}
+
+func (e *EnumTagError) Error() string {
+	return "bitarray: invalid enum pair " + strconv.Quote(e.Pair) + ": " + ErrInvalidEnumTag.Error()[len("bitarray: "):]
+}
+
+func (e *EnumTagError) Unwrap() error { return ErrInvalidEnumTag }
+
+// RegisterEnum registers the set of valid values of the integer type t.
+// Fields of type t without their own enum tag are checked against values
+// by Unmarshal. values maps each valid value to its name; values of signed
+// types are given as uint64(v).
+func RegisterEnum(t reflect.Type, values map[uint64]string) {
+	enumMu.Lock()
+	defer enumMu.Unlock()
+	m := make(map[uint64]string, len(values))
+	for k, v := range values {
+		m[k] = v
+	}
+	enums[t] = m
+	layoutCache.Clear()
+}
+
+// enumValues returns the value set for field f of size bits. The enum tag
+// takes precedence over values registered with RegisterEnum. Values of
+// signed fields are kept as their two's complement bits, as they are
+// decoded. It returns nil if any value is accepted.
+func enumValues(f reflect.StructField, size int) (map[uint64]string, error) {
+	signed := isSigned(f.Type)
+	tag := f.Tag.Get("enum")
+	if len(tag) == 0 {
+		enumMu.RLock()
+		defer enumMu.RUnlock()
+		registered := enums[f.Type]
+		if registered == nil || !signed {
+			return registered, nil
+		}
+		values := make(map[uint64]string, len(registered))
+		for k, v := range registered {
+			// Values that do not fit cannot be decoded.
+			if bits, ok := twosComplement(int64(k), size); ok {
+				values[bits] = v
+			}
+		}
+		return values, nil
+	}
+	values := make(map[uint64]string)
+	for _, pair := range strings.Split(tag, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return nil, &EnumTagError{Pair: pair}
+		}
+		var v uint64
+		var err error
+		if signed {
+			var i int64
+			var ok bool
+			if i, err = strconv.ParseInt(kv[0], 0, 64); err == nil {
+				if v, ok = twosComplement(i, size); !ok {
+					return nil, &EnumTagError{Pair: pair}
+				}
+			}
+		} else if v, err = strconv.ParseUint(kv[0], 0, 64); err == nil && v>>uint(size) != 0 {
+			return nil, &EnumTagError{Pair: pair}
+		}
+		if err != nil {
+			return nil, &EnumTagError{Pair: pair}
+		}
+		values[v] = kv[1]
+	}
+	return values, nil
+}
+
+func isSigned(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// SignExtend returns the two's complement integer held in the lowest size
+// bits of v, such as the bits of a signed field popped from a Buffer.
+func SignExtend(v uint64, size int) int64 {
+	if size == 0 {
+		return 0
+	}
+	shift := uint(64 - size)
+	return int64(v<<shift) >> shift
+}
+
+// twosComplement returns i as a two's complement integer of size bits, and
+// false if it does not fit.
+func twosComplement(i int64, size int) (uint64, bool) {
+	if size == 0 {
+		return 0, i == 0
+	}
+	if size < 64 && (i < -1<<uint(size-1) || i >= 1<<uint(size-1)) {
+		return 0, false
+	}
+	return uint64(i) & (^uint64(0) >> uint(64-size)), true
+}
+
 // Decoder reads and decodes bit array objects from an input stream
 type Decoder struct {
-	buf *Buffer
+	buf       *Buffer
+	opts      DecoderOptions
+	warned    bool   // flag if truncation has been logged.
+	start     uint64 // position of buf when the Decoder is created.
+	depth     int    // nesting depth of structs being decoded.
+	allocated uint64 // bytes allocated for slice and string fields.
 }
 
-// NewDecoder returns a new Decoder that reads from b.
-func NewDecoder(b *Buffer) *Decoder {
-	return &Decoder{
-		buf: b,
+// NewDecoder returns a new Decoder that reads from b. At most one
+// DecoderOptions may be given; without it the defaults are used.
+func NewDecoder(b *Buffer, opts ...DecoderOptions) *Decoder {
+	d := &Decoder{
+		buf:   b,
+		start: b.pos,
+	}
+	if len(opts) > 0 {
+		d.opts = opts[0]
 	}
+	return d
 }
 
 func Unmarshal(b *Buffer, v interface{}) error {
@@ -52,100 +199,288 @@ func (d *Decoder) Unmarshal(v interface{}) error {
 	}
 
 	st := reflect.ValueOf(v).Elem()
-	typ := st.Type()
-	if typ.Kind() != reflect.Struct {
+	if st.Kind() != reflect.Struct {
 		return errors.New("bitarray.Unmarshal: invalid type " + st.String())
 	}
+	return d.decodeStruct(st)
+}
 
-	for i := 0; i < st.NumField(); i++ {
-		field := st.Field(i)
-		sizeStr := typ.Field(i).Tag.Get("bits")
-		if len(sizeStr) == 0 {
-			sizeStr = typ.Field(i).Tag.Get("binary")
-			if len(sizeStr) == 0 {
-				continue
-			}
-			if field.Kind() != reflect.Slice {
-				return ErrUnsupportedFieldType
-			}
-			// TODO(ymotongpoo): Confirm element type check of slice field.
-			// following implementation cause panic when the slice is zero value.
-			//
-			//	if field.Index(0).Kind() != reflect.Uint8 {
-			//		return ErrUnsupportedFieldType
-			//	}
-			//
-		}
+// DecodeAll decodes records from the buffer until it is exhausted, but for
+// the padding of its last byte, and appends them to the slice of structs
+// pointed to by v.
+func (d *Decoder) DecodeAll(v interface{}) error {
+	kind := reflect.ValueOf(v).Kind()
+	if kind != reflect.Ptr {
+		return errors.New("bitarray.DecodeAll: invalid type " + kind.String())
+	}
 
-		size, err := strconv.ParseUint(sizeStr, 0, 64)
-		if err != nil {
+	sl := reflect.ValueOf(v).Elem()
+	if sl.Kind() != reflect.Slice || sl.Type().Elem().Kind() != reflect.Struct {
+		return errors.New("bitarray.DecodeAll: invalid type " + sl.String())
+	}
+	for {
+		more, err := d.more()
+		if !more {
 			return err
 		}
+		elem := reflect.New(sl.Type().Elem()).Elem()
+		if err := d.decodeStruct(elem); err != nil {
+			return err
+		}
+		sl.Set(reflect.Append(sl, elem))
+	}
+}
 
-		// TODO(ymotongpoo): Require refactoring
-		switch field.Kind() {
-		case reflect.Uint8:
-			if size > Uint8Size {
-				return ErrFieldSizeTooLarge
-			}
-			bit, err := d.buf.PopUint8(size)
+// more reports whether another record follows in the buffer. As in
+// DecodeParallel, fewer than 8 bits left after a record that does not end
+// on a byte border are padding rather than the start of a record.
+func (d *Decoder) more() (bool, error) {
+	if eof, err := d.buf.AtEOF(); eof || err != nil {
+		return false, err
+	}
+	pad, err := d.buf.atPadding()
+	return !pad && err == nil, err
+}
+
+// DecodeMap decodes a record laid out as l, typically one built by
+// NewLayout, into a map from field names to values. Values are uint64, or
+// int64 for signed fields, bool, []byte and string, and
+// []map[string]interface{} for SliceKind fields. Fields named "_" are
+// decoded but left out of the map.
+func (d *Decoder) DecodeMap(l *StructLayout) (map[string]interface{}, error) {
+	m := mapRecord{}
+	if err := d.decodeRecord(l, m); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+func (d *Decoder) decodeStruct(st reflect.Value) error {
+	l, err := layoutOf(st.Type())
+	if err != nil {
+		return err
+	}
+	return d.decodeRecord(l, structRecord{st})
+}
+
+// decodeRecord decodes the fields of l into r.
+func (d *Decoder) decodeRecord(l *StructLayout, r record) error {
+	defer d.leave()
+	if err := d.enter(); err != nil {
+		return err
+	}
+	span, _ := r.(spanRecord)
+	sums := newChecksums(l, d.buf)
+	defer sums.stop()
+	for i := range l.Fields {
+		f := &l.Fields[i]
+		next := uint64(0)
+		if f.Static {
+			next = uint64(f.Bits)
+		}
+		if err := d.checkBits(next); err != nil {
+			return err
+		}
+		if span != nil {
+			span.begin(f)
+		}
+		sums.begin(i)
+		switch f.Kind {
+		case UintKind:
+			bit, err := d.buf.PopUint64(uint64(f.Bits))
 			if err != nil && err != io.EOF {
 				return err
 			}
-			if typ.Field(i).Name == "_" {
-				continue
+			if _, ok := f.Enum[bit]; f.Enum != nil && !ok {
+				return &EnumError{
+					Field: f.Name,
+					Type:  r.fieldType(f),
+					Value: f.widen(bit),
+				}
 			}
-			st.Field(i).SetUint(uint64(bit))
-		case reflect.Uint16:
-			if size > Uint16Size {
-				return ErrFieldSizeTooLarge
+			if f.CRC != nil && bit != sums.sum(i) {
+				return &ChecksumError{Field: f.Name, Want: sums.sum(i), Value: bit}
 			}
-			bit, err := d.buf.PopUint16(size)
+			r.setUint(f, bit)
+		case BoolKind:
+			bit, err := d.buf.PopUint64(uint64(f.Bits))
 			if err != nil && err != io.EOF {
 				return err
 			}
-			if typ.Field(i).Name == "_" {
-				continue
+			r.setBool(f, bit != 0)
+		case BytesKind:
+			if err := d.decodeBytes(r, f); err != nil {
+				return err
 			}
-			st.Field(i).SetUint(uint64(bit))
-		case reflect.Uint32:
-			if size > Uint32Size {
-				return ErrFieldSizeTooLarge
+		case SliceKind:
+			if err := d.decodeSlice(r, f); err != nil {
+				return err
 			}
-			bit, err := d.buf.PopUint32(size)
+		case StringKind:
+			data, err := d.popString(uint64(f.Bits)/Uint8Size, f.Mode)
 			if err != nil && err != io.EOF {
 				return err
 			}
-			if typ.Field(i).Name == "_" {
-				continue
+			str, err := DecodeCharset(f.Charset, data)
+			if err != nil {
+				return err
 			}
-			st.Field(i).SetUint(uint64(bit))
-		case reflect.Uint64:
-			if size > Uint64Size {
-				return ErrFieldSizeTooLarge
+			r.setString(f, str)
+		default:
+			if err := d.decodeUnknown(l, r, f); err != nil {
+				return err
 			}
-			bit, err := d.buf.PopUint64(size)
-			if err != nil && err != io.EOF {
+		}
+		sums.end(i)
+		if span != nil {
+			span.end(f)
+		}
+		if err := d.checkShort(l, f); err != nil {
+			return err
+		}
+		if err := d.checkBits(0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkShort reports the field f of l if it has been cut short by the end
+// of the buffer. It is an error only with StrictEOF.
+func (d *Decoder) checkShort(l *StructLayout, f *FieldLayout) error {
+	if !d.buf.short {
+		return nil
+	}
+	if d.opts.StrictEOF {
+		return &FieldError{Type: l.Type, Field: f.Name, Err: io.ErrUnexpectedEOF}
+	}
+	if !d.warned {
+		d.logf("bitarray: field %s of %s is cut short by end of buffer", f.Name, l.Name)
+		d.warned = true
+	}
+	return nil
+}
+
+// decodeBytes decodes the field f of r, which is a slice of bytes.
+func (d *Decoder) decodeBytes(r record, f *FieldLayout) error {
+	if len(f.Until) != 0 {
+		data := []byte{}
+		for {
+			eof, err := d.buf.AtEOF()
+			if err != nil {
+				return err
+			}
+			if eof {
+				break
+			}
+			if err := d.checkSliceLen(uint64(len(data)) + 1); err != nil {
 				return err
 			}
-			if typ.Field(i).Name == "_" {
-				continue
+			if err := d.alloc(1); err != nil {
+				return err
 			}
-			st.Field(i).SetUint(bit)
-		case reflect.Slice:
-			data, err := d.buf.PopBytes(size)
+			byt, err := d.buf.PopUint8(Uint8Size)
 			if err != nil && err != io.EOF {
 				return err
 			}
-			if typ.Field(i).Name == "_" {
-				continue
+			data = append(data, byt)
+		}
+		r.setBytes(f, data)
+		return nil
+	}
+
+	n := uint64(f.Bits) / Uint8Size
+	if !f.Static {
+		var err error
+		if n, err = count(r, f.Count); err != nil {
+			return err
+		}
+	}
+	if err := d.checkSliceLen(n); err != nil {
+		return err
+	}
+	if err := d.alloc(n); err != nil {
+		return err
+	}
+	data, err := d.buf.PopBytes(n)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	r.setBytes(f, data)
+	return nil
+}
+
+// decodeSlice decodes the field f of r, which is a slice of records.
+func (d *Decoder) decodeSlice(r record, f *FieldLayout) error {
+	r.makeSlice(f)
+	if len(f.Until) != 0 {
+		for n := uint64(1); ; n++ {
+			eof, err := d.buf.AtEOF()
+			if err != nil {
+				return err
 			}
-			st.Field(i).SetBytes(data)
-		default:
-			log.Printf("%s: Failed to get type (%s)", typ.Field(i).Name, typ.Kind())
-			// TODO(ymotongpoo): Add exceptional process
-			return nil
+			if eof {
+				return nil
+			}
+			if err := d.checkSliceLen(n); err != nil {
+				return err
+			}
+			if err := d.alloc(r.elemSize(f)); err != nil {
+				return err
+			}
+			elem := r.elem(f)
+			pos := d.buf.pos
+			if err := d.decodeRecord(f.Elem, elem); err != nil {
+				return err
+			}
+			if d.buf.pos == pos {
+				return ErrNoProgress
+			}
+			r.appendElem(f, elem)
+		}
+	}
+
+	n, err := count(r, f.Count)
+	if err != nil {
+		return err
+	}
+	if err := d.checkSliceLen(n); err != nil {
+		return err
+	}
+	// Elements past the end of the buffer are not decoded, so that a
+	// bogus count does not produce a flood of zero elements.
+	for j := uint64(0); j < n && !d.buf.short; j++ {
+		if err := d.alloc(r.elemSize(f)); err != nil {
+			return err
+		}
+		elem := r.elem(f)
+		pos := d.buf.pos
+		if err := d.decodeRecord(f.Elem, elem); err != nil {
+			return err
+		}
+		// A count read from the data must not make empty elements
+		// pile up without end.
+		if d.buf.pos == pos && !d.buf.short {
+			return ErrNoProgress
 		}
+		r.appendElem(f, elem)
 	}
 	return nil
 }
+
+// A uintField looks up uint fields by name for count tags.
+type uintField interface {
+	uint(name string) (uint64, bool)
+}
+
+// count returns the element count given by a count tag, which is either a
+// number or the name of a uint field of r decoded beforehand.
+func count(r uintField, tag string) (uint64, error) {
+	if n, err := strconv.ParseUint(tag, 0, 64); err == nil {
+		return n, nil
+	}
+	if n, ok := r.uint(tag); ok {
+		return n, nil
+	}
+	return 0, ErrInvalidCountTag
+}
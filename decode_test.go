@@ -17,6 +17,7 @@ package bitstring
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"reflect"
 	"testing"
@@ -112,3 +113,286 @@ func TestUnmarsharlCase2(t *testing.T) {
 		t.Errorf("want=%#v, out: %#v", want, out)
 	}
 }
+
+// TestUnmarshal: Case 3) Extract bool fields and enum fields.
+func TestUnmarshalCase3(t *testing.T) {
+	type State uint8
+	type S struct {
+		F1 bool  `bits:"1"`
+		F2 bool  `bits:"3"`
+		F3 State `bits:"4" enum:"0=Idle,1=Run,2=Fault"`
+	}
+
+	data := MustParseBitString("1 010 0010").Bytes()
+
+	buf := bytes.NewBuffer(data)
+	b := NewBuffer(buf)
+	out := &S{}
+	err := Unmarshal(b, out)
+	if err != nil && err != io.EOF {
+		t.Error(err)
+	}
+
+	want := &S{
+		F1: true,
+		F2: true,
+		F3: State(2),
+	}
+	if !reflect.DeepEqual(want, out) {
+		t.Errorf("want=%#v, out: %#v", want, out)
+	}
+}
+
+// TestUnmarshal: Case 4) Reject values out of enum value set.
+func TestUnmarshalCase4(t *testing.T) {
+	type State uint8
+	type S struct {
+		F1 uint8 `bits:"4"`
+		F2 State `bits:"4"`
+	}
+	RegisterEnum(reflect.TypeOf(State(0)), map[uint64]string{0: "Idle", 1: "Run"})
+
+	var data = []byte{
+		0x13, // 0001|0011
+	}
+
+	buf := bytes.NewBuffer(data)
+	b := NewBuffer(buf)
+	out := &S{}
+	err := Unmarshal(b, out)
+	enumErr, ok := err.(*EnumError)
+	if !ok {
+		t.Fatalf("want *EnumError, out: %v", err)
+	}
+	if enumErr.Field != "F2" || enumErr.Value != 3 {
+		t.Errorf("unexpected error: %v", enumErr)
+	}
+}
+
+// TestUnmarshal: Case 5) Extract slices of structs by count and until tags.
+func TestUnmarshalCase5(t *testing.T) {
+	type TLV struct {
+		Type   uint8  `bits:"4"`
+		Length uint8  `bits:"4"`
+		Value  []byte `count:"Length"`
+	}
+	type S struct {
+		N       uint8 `bits:"8"`
+		Fixed   []TLV `count:"2"`
+		Counted []TLV `count:"N"`
+		Rest    []TLV `until:"eof"`
+	}
+
+	var data = []byte{
+		0x01,       // N
+		0x10,       // Fixed[0]: 0001|0000
+		0x21, 0xaa, // Fixed[1]: 0010|0001
+		0x32, 0xbb, 0xcc, // Counted[0]: 0011|0010
+		0x41, 0xdd, // Rest[0]: 0100|0001
+		0x50, // Rest[1]: 0101|0000
+	}
+
+	buf := bytes.NewBuffer(data)
+	b := NewBuffer(buf)
+	out := &S{}
+	err := Unmarshal(b, out)
+	if err != nil && err != io.EOF {
+		t.Error(err)
+	}
+
+	want := &S{
+		N: 1,
+		Fixed: []TLV{
+			{Type: 1, Length: 0, Value: []byte{}},
+			{Type: 2, Length: 1, Value: []byte{0xaa}},
+		},
+		Counted: []TLV{
+			{Type: 3, Length: 2, Value: []byte{0xbb, 0xcc}},
+		},
+		Rest: []TLV{
+			{Type: 4, Length: 1, Value: []byte{0xdd}},
+			{Type: 5, Length: 0, Value: []byte{}},
+		},
+	}
+	if !reflect.DeepEqual(want, out) {
+		t.Errorf("want=%#v, out: %#v", want, out)
+	}
+}
+
+// TestUnmarshal: Case 6) Extract signed integer fields, with enum value sets.
+func TestUnmarshalCase6(t *testing.T) {
+	type Level int8
+	type S struct {
+		F1 Level `bits:"4" enum:"-8=Min,-1=Low,0=Zero,7=Max"`
+		F2 int16 `bits:"12"`
+	}
+
+	var data = []byte{
+		0xf8, 0x00, // 1111|1000_0000_0000
+	}
+
+	out := &S{}
+	if err := Unmarshal(NewBuffer(bytes.NewBuffer(data)), out); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	want := &S{F1: -1, F2: -2048}
+	if !reflect.DeepEqual(want, out) {
+		t.Errorf("want=%#v, out: %#v", want, out)
+	}
+	if in, err := Marshal(want); err != nil || !bytes.Equal(in, data) {
+		t.Errorf("want=%x, out: %x, %v", data, in, err)
+	}
+
+	err := Unmarshal(NewBuffer(bytes.NewBuffer([]byte{0xe0, 0x00})), out)
+	if enumErr, ok := err.(*EnumError); !ok || int64(enumErr.Value) != -2 {
+		t.Errorf("want *EnumError of -2, out: %v", err)
+	} else if msg := "bitarray: invalid value -2 for field F1 of type bitstring.Level"; err.Error() != msg {
+		t.Errorf("want=%q, out: %q", msg, err.Error())
+	}
+	if _, err := Marshal(&S{F1: 8}); err == nil {
+		t.Error("want=error for a value out of range, out: nil")
+	}
+
+	for _, v := range []interface{}{
+		&struct {
+			F Level `bits:"4" enum:"0=Zero,Low"`
+		}{},
+		&struct {
+			F Level `bits:"4" enum:"8=Max"`
+		}{},
+	} {
+		err := Unmarshal(NewBuffer(bytes.NewBuffer(data)), v)
+		var tagErr *EnumTagError
+		if !errors.As(err, &tagErr) || !errors.Is(err, ErrInvalidEnumTag) {
+			t.Errorf("want=*EnumTagError, out: %v", err)
+		}
+	}
+}
+
+func TestUnmarshalEnumTagError(t *testing.T) {
+	type State uint8
+	for _, v := range []interface{}{
+		&struct {
+			F State `bits:"2" enum:"0=A,7=B"`
+		}{},
+		&struct {
+			F State `bits:"2" enum:"0=A,-1=B"`
+		}{},
+	} {
+		err := Unmarshal(NewBuffer(bytes.NewBuffer([]byte{0x00})), v)
+		var tagErr *EnumTagError
+		if !errors.As(err, &tagErr) || !errors.Is(err, ErrInvalidEnumTag) {
+			t.Errorf("want=*EnumTagError, out: %v", err)
+		}
+	}
+	out := &struct {
+		F State `bits:"2" enum:"0=A,3=B"`
+	}{}
+	if err := Unmarshal(NewBuffer(bytes.NewBuffer([]byte{0xc0})), out); err != nil || out.F != 3 {
+		t.Errorf("want=3, out: %d, %v", out.F, err)
+	}
+}
+
+func TestDecodeAll(t *testing.T) {
+	type S struct {
+		F1 uint8  `bits:"4"`
+		F2 uint16 `bits:"12"`
+	}
+
+	data := MustParseBitString(`
+		0001 1111_1111_1111
+		0010 0000_0000_0001
+		0011 0000_0001_0000`).Bytes()
+
+	buf := bytes.NewBuffer(data)
+	d := NewDecoder(NewBuffer(buf))
+	var out []S
+	if err := d.DecodeAll(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []S{
+		{F1: 0x1, F2: 0xfff},
+		{F1: 0x2, F2: 0x001},
+		{F1: 0x3, F2: 0x010},
+	}
+	if !reflect.DeepEqual(want, out) {
+		t.Errorf("want=%#v, out: %#v", want, out)
+	}
+
+	empty := NewDecoder(NewBuffer(bytes.NewBuffer(nil)))
+	out = nil
+	if err := empty.DecodeAll(&out); err != nil || len(out) != 0 {
+		t.Errorf("want no records, out: %#v, %v", out, err)
+	}
+}
+
+func TestDecodeAllPadding(t *testing.T) {
+	type S struct {
+		A uint16 `bits:"12"`
+	}
+	cases := []struct {
+		data []byte
+		want []S
+	}{
+		{[]byte{0xab, 0xc0}, []S{{A: 0xabc}}},
+		{[]byte{0xab, 0xcd, 0xef}, []S{{A: 0xabc}, {A: 0xdef}}},
+		{[]byte{0xab, 0xcd, 0xef, 0x12, 0x30}, []S{{A: 0xabc}, {A: 0xdef}, {A: 0x123}}},
+	}
+	for _, c := range cases {
+		for _, strict := range []bool{false, true} {
+			d := NewDecoder(NewBuffer(bytes.NewBuffer(c.data)), DecoderOptions{StrictEOF: strict})
+			var out []S
+			if err := d.DecodeAll(&out); err != nil || !reflect.DeepEqual(c.want, out) {
+				t.Errorf("%x, strict %v: want=%#v, out: %#v, %v", c.data, strict, c.want, out, err)
+			}
+		}
+		// DecodeParallel treats the last bits the same way.
+		if out, err := DecodeParallel[S](c.data, 1); err != nil || !reflect.DeepEqual(c.want, out) {
+			t.Errorf("%x: DecodeParallel want=%#v, out: %#v, %v", c.data, c.want, out, err)
+		}
+	}
+}
+
+// FuzzUnmarshal decodes arbitrary data into a struct with length driven
+// fields under decoder limits. Run with go test -fuzz=FuzzUnmarshal.
+func FuzzUnmarshal(f *testing.F) {
+	type TLV struct {
+		Type   uint8  `bits:"4"`
+		Length uint8  `bits:"4"`
+		Value  []byte `count:"Length"`
+	}
+	type Node struct {
+		N        uint8  `bits:"2"`
+		Flag     bool   `bits:"1"`
+		_        uint8  `bits:"5"`
+		Children []Node `count:"N"`
+	}
+	type S struct {
+		Version uint8  `bits:"4" enum:"1=V1,2=V2"`
+		Count   uint16 `bits:"12"`
+		Name    string `binary:"pstring8"`
+		Note    string `binary:"zstring"`
+		Nodes   []Node `count:"Count"`
+		TLVs    []TLV  `until:"eof"`
+	}
+
+	f.Add([]byte{0x10, 0x02, 0x02, 'a', 'b', 'c', 0x00, 0x40, 0x00, 0x00, 0x11, 0xff})
+	f.Add([]byte{0x2f, 0xff, 0xff})
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, opts := range []DecoderOptions{
+			{MaxSliceLen: 64, MaxBytes: 1024, MaxDepth: 8, MaxBits: 1 << 12},
+			{StrictEOF: true, MaxDepth: 8},
+		} {
+			d := NewDecoder(NewBuffer(bytes.NewBuffer(data)), opts)
+			out := &S{}
+			if err := d.Unmarshal(out); err != nil {
+				continue
+			}
+			if len(out.Nodes) > int(out.Count) {
+				t.Fatalf("want at most %d nodes, out: %d", out.Count, len(out.Nodes))
+			}
+		}
+	})
+}
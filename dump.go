@@ -0,0 +1,149 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	dumpHexBytes = 8  // bytes of a field shown in hex
+	dumpBitBytes = 4  // bytes of a field shown in binary
+	dumpBytes    = 16 // bytes of a BytesKind value shown
+)
+
+// Dump writes an annotated view of data decoded as l to w: one line per
+// field with its byte offset and bit within the byte, the bytes it occupies
+// in hex and in binary, and its name and value. In the binary column, bits
+// that belong to neighbouring fields are shown as dots. Fields decoded before
+// an error are written before the error is returned.
+func Dump(w io.Writer, l *StructLayout, data []byte) error {
+	v, err := NewDecoder(NewBuffer(bytes.NewReader(data))).DecodeValue(l)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "offset\thex\tbits\tfield")
+	for _, f := range v.Fields {
+		dumpValue(tw, f, f.Name, data, 0)
+	}
+	if ferr := tw.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+func dumpValue(w io.Writer, v *Value, label string, data []byte, depth int) {
+	indent := strings.Repeat("  ", depth)
+	offset := fmt.Sprintf("%04x.%d", v.Offset/Uint8Size, v.Offset%Uint8Size)
+	switch v.Kind {
+	case SliceKind:
+		fmt.Fprintf(w, "%s\t\t\t%s%s: %d elements\n", offset, indent, label, len(v.Fields))
+		for i, e := range v.Fields {
+			dumpValue(w, e, label+"["+strconv.Itoa(i)+"]", data, depth+1)
+		}
+		return
+	case RecordKind:
+		fmt.Fprintf(w, "%s\t\t\t%s%s\n", offset, indent, label)
+		for _, f := range v.Fields {
+			dumpValue(w, f, f.Name, data, depth+1)
+		}
+		return
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s%s: %s\n", offset, dumpHex(v, data), dumpBits(v, data),
+		indent, label, dumpFormat(v))
+}
+
+// span returns the range of bytes of data that v occupies.
+func span(v *Value, data []byte) (int, int) {
+	if v.Bits == 0 {
+		return 0, 0
+	}
+	first := v.Offset / Uint8Size
+	last := (v.Offset+v.Bits-1)/Uint8Size + 1
+	if last > uint64(len(data)) {
+		last = uint64(len(data))
+	}
+	if first > last {
+		first = last
+	}
+	return int(first), int(last)
+}
+
+func dumpHex(v *Value, data []byte) string {
+	first, last := span(v, data)
+	more := ""
+	if last-first > dumpHexBytes {
+		last, more = first+dumpHexBytes, " ..."
+	}
+	hex := make([]string, 0, last-first)
+	for _, b := range data[first:last] {
+		hex = append(hex, fmt.Sprintf("%02x", b))
+	}
+	return strings.Join(hex, " ") + more
+}
+
+func dumpBits(v *Value, data []byte) string {
+	first, last := span(v, data)
+	more := ""
+	if last-first > dumpBitBytes {
+		last, more = first+dumpBitBytes, " ..."
+	}
+	bits := make([]string, 0, last-first)
+	for i := first; i < last; i++ {
+		var s [8]byte
+		for j := uint64(0); j < Uint8Size; j++ {
+			p := uint64(i)*Uint8Size + j
+			switch {
+			case p < v.Offset || p >= v.Offset+v.Bits:
+				s[j] = '.'
+			case data[i]>>(7-j)&1 != 0:
+				s[j] = '1'
+			default:
+				s[j] = '0'
+			}
+		}
+		bits = append(bits, string(s[:]))
+	}
+	return strings.Join(bits, " ") + more
+}
+
+// dumpFormat formats the value of v.
+func dumpFormat(v *Value) string {
+	switch x := v.Value.(type) {
+	case uint64, int64:
+		s := fmt.Sprintf("%d", x)
+		if u, ok := x.(uint64); ok {
+			s += fmt.Sprintf(" (%#x)", u)
+		}
+		if len(v.Enum) != 0 {
+			s += " " + v.Enum
+		}
+		return s
+	case []byte:
+		if len(x) > dumpBytes {
+			return fmt.Sprintf("%x ... (%d bytes)", x[:dumpBytes], len(x))
+		}
+		return fmt.Sprintf("%x (%d bytes)", x, len(x))
+	case string:
+		return strconv.Quote(x)
+	case nil:
+		return "-"
+	}
+	return fmt.Sprint(v.Value)
+}
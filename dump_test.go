@@ -0,0 +1,106 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	type E struct {
+		F1 uint8 `bits:"3" enum:"1=One,2=Two"`
+		F2 bool  `bits:"1"`
+	}
+	type S struct {
+		F1 uint16 `bits:"12"`
+		N  uint8  `bits:"4"`
+		F2 []E    `count:"N"`
+		F3 string `binary:"pstring8"`
+		F4 []byte `until:"eof"`
+	}
+	l, err := Layout(reflect.TypeOf(S{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte{0xab, 0xc2, 0x34, 0x02, 'h', 'i', 0, 1, 2, 3, 4, 5, 6, 7, 8}
+	out := &bytes.Buffer{}
+	if err := Dump(out, l, data); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `offset  hex                          bits                                     field
+0000.0  ab c2                        10101011 1100....                        F1: 2748 (0xabc)
+0001.4  c2                           ....0010                                 N: 2 (0x2)
+0002.0                                                                        F2: 2 elements
+0002.0                                                                          F2[0]
+0002.0  34                           001.....                                     F1: 1 (0x1) One
+0002.3  34                           ...1....                                     F2: true
+0002.4                                                                          F2[1]
+0002.4  34                           ....010.                                     F1: 2 (0x2) Two
+0002.7  34                           .......0                                     F2: false
+0003.0  02 68 69                     00000010 01101000 01101001               F3: "hi"
+0006.0  00 01 02 03 04 05 06 07 ...  00000000 00000001 00000010 00000011 ...  F4: 000102030405060708 (9 bytes)
+`
+	if out.String() != want {
+		t.Errorf("want=\n%s, out:\n%s", want, out.String())
+	}
+}
+
+func TestDumpTruncated(t *testing.T) {
+	type S struct {
+		F1 uint8  `bits:"8"`
+		F2 uint16 `bits:"16"`
+	}
+	l, err := Layout(reflect.TypeOf(S{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err := Dump(out, l, []byte{0x01, 0x02}); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	want := `offset  hex  bits      field
+0000.0  01   00000001  F1: 1 (0x1)
+0001.0  02   00000010  F2: 2 (0x2)
+`
+	if out.String() != want {
+		t.Errorf("want=\n%s, out:\n%s", want, out.String())
+	}
+}
+
+func TestDumpSigned(t *testing.T) {
+	type S struct {
+		F1 int8 `bits:"4" enum:"-1=Low,1=High"`
+		F2 int8 `bits:"4"`
+	}
+	l, err := Layout(reflect.TypeOf(S{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err := Dump(out, l, []byte{0xf9}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"F1: -1 Low\n", "F2: -7\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("want=%q, out:\n%s", want, out.String())
+		}
+	}
+}
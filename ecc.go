@@ -0,0 +1,278 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// ErrUncorrectable is returned for a codeword with more bit errors than its
+// code can correct.
+var ErrUncorrectable = errors.New("bitarray: uncorrectable codeword")
+
+// A BlockCode is an error-correcting code of codewords of N bits, each
+// carrying K data bits. Codewords and data are taken and sent most
+// significant bit first.
+type BlockCode struct {
+	n, k   int
+	encode func(data uint64) uint64
+	decode func(word uint64) (data uint64, corrected int, ok bool)
+	data   func(word uint64) uint64 // data bits of a codeword, uncorrected.
+}
+
+var (
+	// Hamming74 corrects one bit error in 7 bits. Bits are sent in the
+	// classic order p1 p2 d1 p3 d2 d3 d4, d1 being the most significant
+	// data bit.
+	Hamming74 = &BlockCode{n: 7, k: 4, encode: hammingEncode, decode: hammingDecode, data: hammingData}
+
+	// Hamming84 is Hamming74 followed by an even parity bit over the
+	// codeword. It corrects one bit error and detects two.
+	Hamming84 = &BlockCode{n: 8, k: 4, encode: hamming8Encode, decode: hamming8Decode, data: hamming8Data}
+
+	// BCH6351 is the binary BCH code of 63 bits that corrects two bit
+	// errors, over GF(64) with the primitive polynomial x^6+x+1. Codewords
+	// are the 51 data bits followed by 12 check bits.
+	BCH6351 = &BlockCode{n: 63, k: 51, encode: bchEncode, decode: bchDecode, data: bchData}
+)
+
+// N returns the number of bits of a codeword of c.
+func (c *BlockCode) N() int {
+	return c.n
+}
+
+// K returns the number of data bits in a codeword of c.
+func (c *BlockCode) K() int {
+	return c.k
+}
+
+// Encode returns the codeword of the lowest K bits of data.
+func (c *BlockCode) Encode(data uint64) uint64 {
+	return c.encode(data & (1<<uint(c.k) - 1))
+}
+
+// Decode returns the data bits of the lowest N bits of word and the number
+// of bit errors corrected. If word has more errors than c can correct, it
+// returns its data bits as they are and ErrUncorrectable.
+func (c *BlockCode) Decode(word uint64) (uint64, int, error) {
+	word &= 1<<uint(c.n) - 1
+	data, corrected, ok := c.decode(word)
+	if !ok {
+		return c.data(word), 0, ErrUncorrectable
+	}
+	return data, corrected, nil
+}
+
+// Push pushes the codeword of the lowest K bits of data to w.
+func (c *BlockCode) Push(w *Writer, data uint64) error {
+	return w.PushUint64(c.Encode(data), uint64(c.n))
+}
+
+// Pop pops a codeword from b and decodes it as Decode does. It returns
+// io.EOF at the end of b, and io.ErrUnexpectedEOF if b ends within the
+// codeword.
+func (c *BlockCode) Pop(b *Buffer) (uint64, int, error) {
+	if eof, err := b.AtEOF(); eof || err != nil {
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, 0, err
+	}
+	word, err := b.PopUint64(uint64(c.n))
+	if b.Truncated() {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+	return c.Decode(word)
+}
+
+// CodeStats counts the errors in the codewords decoded by DecodeBlocks.
+type CodeStats struct {
+	Codewords     int // codewords decoded
+	Corrected     int // bit errors corrected
+	Uncorrectable int // codewords with too many errors to correct
+}
+
+// DecodeBlocks pops n codewords from b, or codewords up to the end of b if
+// n is negative, and returns their data bits, corrected, one after another.
+// Uncorrectable codewords are counted and their data bits kept as they
+// are, so that only errors of b end the decoding.
+func (c *BlockCode) DecodeBlocks(b *Buffer, n int) (BitString, CodeStats, error) {
+	var bld builder
+	var stats CodeStats
+	for i := 0; n < 0 || i < n; i++ {
+		data, corrected, err := c.Pop(b)
+		switch {
+		case err == io.EOF && n < 0:
+			return bld.bitString(), stats, nil
+		case err == ErrUncorrectable:
+			stats.Uncorrectable++
+		case err != nil:
+			return bld.bitString(), stats, err
+		}
+		stats.Codewords++
+		stats.Corrected += corrected
+		bld.push(data, c.k)
+	}
+	return bld.bitString(), stats, nil
+}
+
+// hammingSyndrome returns the XOR of the positions, from 1 for the most
+// significant bit, of the set bits of the 7 bit word.
+func hammingSyndrome(word uint64) int {
+	s := 0
+	for pos := 1; pos <= 7; pos++ {
+		if word>>uint(7-pos)&1 != 0 {
+			s ^= pos
+		}
+	}
+	return s
+}
+
+func hammingEncode(data uint64) uint64 {
+	// Data bits go to positions 3, 5, 6 and 7.
+	word := data>>3&1<<4 | data>>2&1<<2 | data>>1&1<<1 | data&1
+	s := hammingSyndrome(word)
+	// Parity bits at positions 1, 2 and 4 clear the syndrome.
+	return word | uint64(s&1)<<6 | uint64(s>>1&1)<<5 | uint64(s>>2&1)<<3
+}
+
+func hammingData(word uint64) uint64 {
+	return word>>4&1<<3 | word>>2&1<<2 | word>>1&1<<1 | word&1
+}
+
+func hammingDecode(word uint64) (uint64, int, bool) {
+	s := hammingSyndrome(word)
+	if s == 0 {
+		return hammingData(word), 0, true
+	}
+	return hammingData(word ^ 1<<uint(7-s)), 1, true
+}
+
+func hamming8Encode(data uint64) uint64 {
+	word := hammingEncode(data)
+	return word<<1 | uint64(bits.OnesCount64(word)&1)
+}
+
+func hamming8Data(word uint64) uint64 {
+	return hammingData(word >> 1)
+}
+
+func hamming8Decode(word uint64) (uint64, int, bool) {
+	s := hammingSyndrome(word >> 1)
+	odd := bits.OnesCount64(word)&1 != 0
+	switch {
+	case s == 0 && !odd:
+		return hamming8Data(word), 0, true
+	case odd:
+		// A single error, in the parity bit if the syndrome is clear.
+		if s != 0 {
+			word ^= 1 << uint(8-s)
+		}
+		return hamming8Data(word), 1, true
+	}
+	return 0, 0, false // Two errors
+}
+
+// bchGenerator is the generator polynomial of BCH6351, the product of the
+// minimal polynomials x^6+x+1 and x^6+x^4+x^2+x+1 of a and a^3.
+const bchGenerator = 0x1539
+
+// gf64Exp and gf64Log are the powers and logarithms of a in GF(64).
+var gf64Exp, gf64Log = func() (exp [126]uint8, log [64]int) {
+	x := uint8(1)
+	for i := 0; i < 63; i++ {
+		exp[i], exp[i+63] = x, x
+		log[x] = i
+		x <<= 1
+		if x&0x40 != 0 {
+			x ^= 0x43
+		}
+	}
+	return exp, log
+}()
+
+func gf64Mul(a, b uint8) uint8 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gf64Exp[gf64Log[a]+gf64Log[b]]
+}
+
+func gf64Div(a, b uint8) uint8 {
+	if a == 0 {
+		return 0
+	}
+	return gf64Exp[gf64Log[a]+63-gf64Log[b]]
+}
+
+func bchEncode(data uint64) uint64 {
+	word := data << 12
+	rem := word
+	for i := 62; i >= 12; i-- {
+		if rem>>uint(i)&1 != 0 {
+			rem ^= bchGenerator << uint(i-12)
+		}
+	}
+	return word | rem
+}
+
+// bchSyndrome returns the value of the polynomial of word at a^j.
+func bchSyndrome(word uint64, j int) uint8 {
+	var s uint8
+	for i := 0; i < 63; i++ {
+		if word>>uint(i)&1 != 0 {
+			s ^= gf64Exp[i*j%63]
+		}
+	}
+	return s
+}
+
+func bchData(word uint64) uint64 {
+	return word >> 12
+}
+
+func bchDecode(word uint64) (uint64, int, bool) {
+	s1, s3 := bchSyndrome(word, 1), bchSyndrome(word, 3)
+	if s1 == 0 && s3 == 0 {
+		return word >> 12, 0, true
+	}
+	if s1 == 0 {
+		return 0, 0, false
+	}
+	s1cube := gf64Mul(s1, gf64Mul(s1, s1))
+	if s3 == s1cube {
+		return (word ^ 1<<uint(gf64Log[s1])) >> 12, 1, true
+	}
+	// The error locator 1 + s1 x + sigma2 x^2 has the inverses of the
+	// error positions as roots.
+	sigma2 := gf64Div(s3^s1cube, s1)
+	var errs []int
+	for i := 0; i < 63; i++ {
+		x := gf64Exp[(63-i)%63]
+		if 1^gf64Mul(s1, x)^gf64Mul(sigma2, gf64Mul(x, x)) == 0 {
+			errs = append(errs, i)
+		}
+	}
+	if len(errs) != 2 {
+		return 0, 0, false
+	}
+	return (word ^ 1<<uint(errs[0]) ^ 1<<uint(errs[1])) >> 12, 2, true
+}
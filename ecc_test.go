@@ -0,0 +1,139 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestHamming74(t *testing.T) {
+	// Codewords of the classic p1 p2 d1 p3 d2 d3 d4 layout.
+	for _, c := range []struct{ data, word uint64 }{
+		{0x0, 0x00}, {0xf, 0x7f}, {0xb, 0x33}, {0x1, 0x69}, {0x8, 0x70},
+	} {
+		if out := Hamming74.Encode(c.data); out != c.word {
+			t.Errorf("want=%#v, out: %#v", c.word, out)
+		}
+	}
+	for data := uint64(0); data < 16; data++ {
+		word := Hamming74.Encode(data)
+		for i := -1; i < 7; i++ {
+			want := 0
+			if i >= 0 {
+				word, want = word^1<<uint(i), 1
+			}
+			out, corrected, err := Hamming74.Decode(word)
+			if out != data || corrected != want || err != nil {
+				t.Errorf("want=%#v, %d, out: %#v, %d, %v", data, want, out, corrected, err)
+			}
+			if i >= 0 {
+				word ^= 1 << uint(i)
+			}
+		}
+	}
+}
+
+func TestHamming84(t *testing.T) {
+	for data := uint64(0); data < 16; data++ {
+		word := Hamming84.Encode(data)
+		for i := 0; i < 8; i++ {
+			out, corrected, err := Hamming84.Decode(word ^ 1<<uint(i))
+			if out != data || corrected != 1 || err != nil {
+				t.Errorf("want=%#v, out: %#v, %d, %v", data, out, corrected, err)
+			}
+			for j := i + 1; j < 8; j++ {
+				if _, _, err := Hamming84.Decode(word ^ 1<<uint(i) ^ 1<<uint(j)); err != ErrUncorrectable {
+					t.Errorf("want=%v, out: %v", ErrUncorrectable, err)
+				}
+			}
+		}
+	}
+
+	// Two errors in the parity bits p1 and p2 leave the data bits as they are.
+	word := Hamming84.Encode(0xa) ^ 0xc0
+	if out, _, err := Hamming84.Decode(word); out != 0xa || err != ErrUncorrectable {
+		t.Errorf("want=%#v, %v, out: %#v, %v", 0xa, ErrUncorrectable, out, err)
+	}
+}
+
+func TestBCH6351(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		data := r.Uint64() & (1<<51 - 1)
+		word := BCH6351.Encode(data)
+		if word>>12 != data {
+			t.Fatalf("want=%#v, out: %#v", data, word>>12)
+		}
+		errs := i % 3
+		for flipped := 0; flipped < errs; {
+			bit := uint64(1) << uint(r.Intn(63))
+			if word&bit == BCH6351.Encode(data)&bit {
+				word ^= bit
+				flipped++
+			}
+		}
+		out, corrected, err := BCH6351.Decode(word)
+		if out != data || corrected != errs || err != nil {
+			t.Errorf("want=%#v, %d, out: %#v, %d, %v", data, errs, out, corrected, err)
+		}
+	}
+	// Three errors are beyond the code; they are either detected or
+	// miscorrected, never reported as clean.
+	word := BCH6351.Encode(0x123456789abc) ^ 0x7
+	if _, corrected, err := BCH6351.Decode(word); err == nil && corrected == 0 {
+		t.Errorf("want=errors found, out: %d, %v", corrected, err)
+	}
+}
+
+func TestBlockCodeBuffer(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	for _, data := range []uint64{0x3, 0xc, 0x5} {
+		if err := Hamming84.Push(w, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+	data := out.Bytes()
+	data[0] ^= 0x10 // One error in the first codeword.
+	data[2] ^= 0x41 // Two errors in the third.
+
+	s, stats, err := Hamming84.DecodeBlocks(NewBuffer(bytes.NewReader(data)), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (CodeStats{Codewords: 3, Corrected: 1, Uncorrectable: 1}); stats != want {
+		t.Errorf("want=%#v, out: %#v", want, stats)
+	}
+	if want := MustParseBitString("0b0011_1100_0101"); !s.Equal(want) {
+		t.Errorf("want=%v, out: %v", want, s)
+	}
+
+	b := MustParseBitString("0b1010101").Reader()
+	if out, _, err := Hamming74.Pop(b); out != 0xd || err != nil {
+		t.Errorf("want=%#v, out: %#v, %v", 0xd, out, err)
+	}
+	if _, _, err := Hamming74.Pop(b); err != io.EOF {
+		t.Errorf("want=%v, out: %v", io.EOF, err)
+	}
+	b = MustParseBitString("0b1010").Reader()
+	if _, _, err := Hamming74.Pop(b); err != io.ErrUnexpectedEOF {
+		t.Errorf("want=%v, out: %v", io.ErrUnexpectedEOF, err)
+	}
+}
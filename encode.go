@@ -0,0 +1,466 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+)
+
+var (
+	ErrValueOverflow = errors.New("bitarray: value does not fit in field")
+	ErrCountMismatch = errors.New("bitarray: number of elements does not match count")
+	ErrMissingValue  = errors.New("bitarray: no value for field")
+	ErrInvalidValue  = errors.New("bitarray: value type does not match field kind")
+)
+
+// Encoder writes bit array objects to an output stream, laid out as Decoder
+// reads them.
+type Encoder struct {
+	w *Writer
+}
+
+// NewEncoder returns a new Encoder that writes to w.
+func NewEncoder(w *Writer) *Encoder {
+	return &Encoder{
+		w: w,
+	}
+}
+
+// Marshal returns the bit array encoding of the tagged struct v, padded with
+// zero bits to a whole byte. It is the inverse of Unmarshal.
+func Marshal(v interface{}) ([]byte, error) {
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	if err := NewEncoder(w).Encode(v); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Encode encodes the tagged struct v, or the struct v points to, laid out as
+// Decoder.Unmarshal reads it. Fields named "_" are written as zero bits, and
+// fields with a crc tag as the CRC of the fields they cover. Writer is not
+// flushed.
+func (e *Encoder) Encode(v interface{}) error {
+	st := reflect.ValueOf(v)
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	if st.Kind() != reflect.Struct {
+		return errors.New("bitarray.Encode: invalid type " + st.Kind().String())
+	}
+	l, err := layoutOf(st.Type())
+	if err != nil {
+		return err
+	}
+	return e.encodeRecord(l, structSource{st})
+}
+
+// EncodeMap encodes m laid out as l. It is the inverse of Decoder.DecodeMap:
+// values of UintKind fields may be of any integer type, BytesKind values are
+// []byte or string, and SliceKind values are slices of maps. Values of fields
+// named "_" are zero. Writer is not flushed.
+func (e *Encoder) EncodeMap(l *StructLayout, m map[string]interface{}) error {
+	return e.encodeRecord(l, mapSource(m))
+}
+
+// A source supplies the values of fields to encode.
+type source interface {
+	uintField
+	getUint(f *FieldLayout) (uint64, error)
+	getBool(f *FieldLayout) (bool, error)
+	getBytes(f *FieldLayout) ([]byte, error)
+	getString(f *FieldLayout) (string, error)
+	getElems(f *FieldLayout) ([]source, error)
+	fieldType(f *FieldLayout) reflect.Type
+}
+
+// encodeRecord encodes the fields of l taken from src. Checksum fields are
+// filled in with the CRC of the fields they cover.
+func (e *Encoder) encodeRecord(l *StructLayout, src source) error {
+	sums := newChecksums(l, e.w)
+	defer sums.stop()
+	for i := range l.Fields {
+		f := &l.Fields[i]
+		sums.begin(i)
+		var err error
+		if f.CRC != nil {
+			err = e.w.PushUint64(sums.sum(i), uint64(f.Bits))
+		} else {
+			err = e.encodeField(f, src)
+		}
+		sums.end(i)
+		if err != nil {
+			if _, ok := err.(*FieldError); ok {
+				return err
+			}
+			if _, ok := err.(*EnumError); ok {
+				return err
+			}
+			return &FieldError{Type: l.Type, Field: f.Name, Err: err}
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) encodeField(f *FieldLayout, src source) error {
+	switch f.Kind {
+	case UintKind:
+		v, err := src.getUint(f)
+		if err != nil {
+			return err
+		}
+		if f.Bits < int(Uint64Size) && v>>uint(f.Bits) != 0 {
+			return ErrValueOverflow
+		}
+		if _, ok := f.Enum[v]; f.Enum != nil && !ok {
+			return &EnumError{Field: f.Name, Type: src.fieldType(f), Value: f.widen(v)}
+		}
+		return e.w.PushUint64(v, uint64(f.Bits))
+	case BoolKind:
+		v, err := src.getBool(f)
+		if err != nil {
+			return err
+		}
+		bit := uint64(0)
+		if v && f.Bits > 0 {
+			bit = 1
+		}
+		return e.w.PushUint64(bit, uint64(f.Bits))
+	case BytesKind:
+		data, err := src.getBytes(f)
+		if err != nil {
+			return err
+		}
+		switch {
+		case len(f.Until) != 0:
+		case len(f.Count) != 0:
+			n, err := count(src, f.Count)
+			if err != nil {
+				return err
+			}
+			if uint64(len(data)) != n {
+				return ErrCountMismatch
+			}
+		default:
+			if data, err = pad(data, f.Bits/int(Uint8Size)); err != nil {
+				return err
+			}
+		}
+		return e.w.PushBytes(data)
+	case StringKind:
+		s, err := src.getString(f)
+		if err != nil {
+			return err
+		}
+		data, err := EncodeCharset(f.Charset, s)
+		if err != nil {
+			return err
+		}
+		return e.pushString(data, f)
+	case SliceKind:
+		elems, err := src.getElems(f)
+		if err != nil {
+			return err
+		}
+		if len(f.Count) != 0 {
+			n, err := count(src, f.Count)
+			if err != nil {
+				return err
+			}
+			if uint64(len(elems)) != n {
+				return ErrCountMismatch
+			}
+		}
+		for _, elem := range elems {
+			if err := e.encodeRecord(f.Elem, elem); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return ErrUnsupportedFieldType
+}
+
+// pushString writes the raw bytes of a string field in the mode of f.
+func (e *Encoder) pushString(data []byte, f *FieldLayout) error {
+	switch f.Mode {
+	case ZString:
+		if bytes.IndexByte(data, 0) >= 0 {
+			return ErrInvalidValue
+		}
+		if err := e.w.PushBytes(data); err != nil {
+			return err
+		}
+		return e.w.PushUint8(0, Uint8Size)
+	case PString8, PString16, PString32:
+		size := prefixSize(f.Mode)
+		if uint64(len(data))>>size != 0 {
+			return ErrValueOverflow
+		}
+		if err := e.w.PushUint64(uint64(len(data)), size); err != nil {
+			return err
+		}
+		return e.w.PushBytes(data)
+	default:
+		data, err := pad(data, f.Bits/int(Uint8Size))
+		if err != nil {
+			return err
+		}
+		return e.w.PushBytes(data)
+	}
+}
+
+// pad returns data padded with zero bytes to size bytes.
+func pad(data []byte, size int) ([]byte, error) {
+	if len(data) > size {
+		return nil, ErrValueOverflow
+	}
+	if len(data) == size {
+		return data, nil
+	}
+	padded := make([]byte, size)
+	copy(padded, data)
+	return padded, nil
+}
+
+// structSource supplies values from a struct, where FieldLayout.Index is the
+// index of the struct field.
+type structSource struct {
+	v reflect.Value
+}
+
+func (s structSource) field(f *FieldLayout) (reflect.Value, bool) {
+	if f.Name == "_" {
+		return reflect.Value{}, false
+	}
+	return s.v.Field(f.Index), true
+}
+
+func (s structSource) uint(name string) (uint64, bool) {
+	return structRecord{s.v}.uint(name)
+}
+
+func (s structSource) getUint(f *FieldLayout) (uint64, error) {
+	v, ok := s.field(f)
+	switch {
+	case !ok:
+		return 0, nil
+	case f.Signed:
+		return signedBits(v.Int(), f)
+	}
+	return v.Uint(), nil
+}
+
+func (s structSource) getBool(f *FieldLayout) (bool, error) {
+	if v, ok := s.field(f); ok {
+		return v.Bool(), nil
+	}
+	return false, nil
+}
+
+func (s structSource) getBytes(f *FieldLayout) ([]byte, error) {
+	if v, ok := s.field(f); ok {
+		return v.Bytes(), nil
+	}
+	return []byte{}, nil
+}
+
+func (s structSource) getString(f *FieldLayout) (string, error) {
+	if v, ok := s.field(f); ok {
+		return v.String(), nil
+	}
+	return "", nil
+}
+
+func (s structSource) getElems(f *FieldLayout) ([]source, error) {
+	v, ok := s.field(f)
+	if !ok {
+		return nil, nil
+	}
+	elems := make([]source, v.Len())
+	for i := range elems {
+		elems[i] = structSource{v.Index(i)}
+	}
+	return elems, nil
+}
+
+func (s structSource) fieldType(f *FieldLayout) reflect.Type {
+	return s.v.Type().Field(f.Index).Type
+}
+
+// mapSource supplies values from a map keyed by field name.
+type mapSource map[string]interface{}
+
+func (m mapSource) value(f *FieldLayout) (interface{}, error) {
+	if f.Name == "_" {
+		return nil, nil
+	}
+	v, ok := m[f.Name]
+	if !ok {
+		return nil, ErrMissingValue
+	}
+	return v, nil
+}
+
+func (m mapSource) uint(name string) (uint64, bool) {
+	return toUint(m[name])
+}
+
+func (m mapSource) getUint(f *FieldLayout) (uint64, error) {
+	v, err := m.value(f)
+	if err != nil || v == nil {
+		return 0, err
+	}
+	if f.Signed {
+		i, ok := toInt(v)
+		if !ok {
+			return 0, ErrInvalidValue
+		}
+		return signedBits(i, f)
+	}
+	n, ok := toUint(v)
+	if !ok {
+		return 0, ErrInvalidValue
+	}
+	return n, nil
+}
+
+// signedBits returns i as the two's complement bits of the signed field f.
+func signedBits(i int64, f *FieldLayout) (uint64, error) {
+	bits, ok := twosComplement(i, f.Bits)
+	if !ok {
+		return 0, ErrValueOverflow
+	}
+	return bits, nil
+}
+
+func (m mapSource) getBool(f *FieldLayout) (bool, error) {
+	v, err := m.value(f)
+	if err != nil || v == nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, ErrInvalidValue
+	}
+	return b, nil
+}
+
+func (m mapSource) getBytes(f *FieldLayout) ([]byte, error) {
+	v, err := m.value(f)
+	if err != nil || v == nil {
+		return []byte{}, err
+	}
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, ErrInvalidValue
+}
+
+func (m mapSource) getString(f *FieldLayout) (string, error) {
+	v, err := m.value(f)
+	if err != nil || v == nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", ErrInvalidValue
+	}
+	return s, nil
+}
+
+func (m mapSource) getElems(f *FieldLayout) ([]source, error) {
+	v, err := m.value(f)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	var elems []source
+	switch v := v.(type) {
+	case []map[string]interface{}:
+		for _, elem := range v {
+			elems = append(elems, mapSource(elem))
+		}
+	case []interface{}:
+		for _, elem := range v {
+			m, ok := elem.(map[string]interface{})
+			if !ok {
+				return nil, ErrInvalidValue
+			}
+			elems = append(elems, mapSource(m))
+		}
+	default:
+		return nil, ErrInvalidValue
+	}
+	return elems, nil
+}
+
+func (m mapSource) fieldType(f *FieldLayout) reflect.Type {
+	return nil
+}
+
+// toUint converts a non-negative integer of any type, or an integral
+// float64 as decoded from JSON, to uint64.
+func toUint(v interface{}) (uint64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rv.Int() < 0 {
+			return 0, false
+		}
+		return uint64(rv.Int()), true
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f < 0 || f != math.Trunc(f) || f >= math.MaxUint64 {
+			return 0, false
+		}
+		return uint64(f), true
+	}
+	return 0, false
+}
+
+// toInt converts an integer of any type, or an integral float64 as decoded
+// from JSON, to int64.
+func toInt(v interface{}) (int64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	}
+	return 0, false
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func encodeTestLayout(t *testing.T) *StructLayout {
+	elem, err := NewLayout("Option", []FieldLayout{
+		{Name: "Type", Kind: UintKind, Bits: 4, Enum: map[uint64]string{1: "A", 2: "B"}},
+		{Name: "On", Kind: BoolKind, Bits: 1},
+		{Name: "_", Kind: UintKind, Bits: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLayout("Packet", []FieldLayout{
+		{Name: "Version", Kind: UintKind, Bits: 4},
+		{Name: "N", Kind: UintKind, Bits: 4},
+		{Name: "Options", Kind: SliceKind, Count: "N", Elem: elem},
+		{Name: "Host", Kind: StringKind, Bits: 32, Mode: CString},
+		{Name: "Path", Kind: StringKind, Mode: PString8},
+		{Name: "Body", Kind: BytesKind, Until: "eof"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// EncodeMap: Case 1) Encoded maps decode to the same map.
+func TestEncodeMapCase1(t *testing.T) {
+	l := encodeTestLayout(t)
+	in := map[string]interface{}{
+		"Version": 4,
+		"N":       uint8(2),
+		"Options": []map[string]interface{}{
+			{"Type": 1, "On": true},
+			{"Type": 2.0, "On": false},
+		},
+		"Host": "ab",
+		"Path": "/x",
+		"Body": []byte{0xde, 0xad},
+	}
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	if err := NewEncoder(w).EncodeMap(l, in); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{0x42, 0x18, 0x20, 'a', 'b', 0, 0, 0x02, '/', 'x', 0xde, 0xad}
+	if !reflect.DeepEqual(want, out.Bytes()) {
+		t.Errorf("want=%x, out: %x", want, out.Bytes())
+	}
+
+	m, err := NewDecoder(NewBuffer(bytes.NewReader(out.Bytes()))).DecodeMap(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantMap := map[string]interface{}{
+		"Version": uint64(4),
+		"N":       uint64(2),
+		"Options": []map[string]interface{}{
+			{"Type": uint64(1), "On": true},
+			{"Type": uint64(2), "On": false},
+		},
+		"Host": "ab",
+		"Path": "/x",
+		"Body": []byte{0xde, 0xad},
+	}
+	if !reflect.DeepEqual(wantMap, m) {
+		t.Errorf("want=%#v, out: %#v", wantMap, m)
+	}
+}
+
+// EncodeMap: Case 2) Values that cannot be laid out are reported.
+func TestEncodeMapCase2(t *testing.T) {
+	l := encodeTestLayout(t)
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"Version": 4, "N": 0, "Options": []map[string]interface{}{},
+			"Host": "", "Path": "", "Body": []byte{},
+		}
+	}
+	cases := []struct {
+		key   string
+		value interface{}
+		want  error
+	}{
+		{"Version", 16, ErrValueOverflow},
+		{"Version", -1, ErrInvalidValue},
+		{"N", 1, ErrCountMismatch},
+		{"Host", "hello", ErrValueOverflow},
+		{"Path", 4, ErrInvalidValue},
+		{"Body", nil, nil},
+	}
+	for _, c := range cases {
+		m := valid()
+		if c.value == nil {
+			delete(m, c.key)
+			c.want = ErrMissingValue
+		} else {
+			m[c.key] = c.value
+		}
+		err := NewEncoder(NewWriter(&bytes.Buffer{})).EncodeMap(l, m)
+		if !errors.Is(err, c.want) {
+			t.Errorf("%s=%v: want=%v, out: %v", c.key, c.value, c.want, err)
+		}
+	}
+
+	m := valid()
+	m["N"] = 1
+	m["Options"] = []interface{}{map[string]interface{}{"Type": 3, "On": true}}
+	err := NewEncoder(NewWriter(&bytes.Buffer{})).EncodeMap(l, m)
+	if _, ok := err.(*EnumError); !ok {
+		t.Errorf("want=*EnumError, out: %#v", err)
+	}
+}
+
+// Marshal: Case 1) Structs are encoded as Unmarshal reads them.
+func TestMarshal(t *testing.T) {
+	type E struct {
+		F1 uint8 `bits:"3"`
+		F2 bool  `bits:"1"`
+	}
+	type S struct {
+		F1 uint16 `bits:"12"`
+		N  uint8  `bits:"4"`
+		F2 []E    `count:"N"`
+		_  uint8  `bits:"8"`
+		F3 string `binary:"4,cstring"`
+		F4 string `binary:"zstring"`
+		F5 []byte `until:"eof"`
+	}
+	in := S{
+		F1: 0xabc,
+		N:  2,
+		F2: []E{{1, true}, {2, false}},
+		F3: "ab",
+		F4: "cd",
+		F5: []byte{0xff},
+	}
+	data, err := Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0xab, 0xc2, 0x34, 0x00, 'a', 'b', 0, 0, 'c', 'd', 0, 0xff}
+	if !reflect.DeepEqual(want, data) {
+		t.Errorf("want=%x, out: %x", want, data)
+	}
+
+	var out S
+	if err := Unmarshal(NewBuffer(bytes.NewReader(data)), &out); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("want=%#v, out: %#v", in, out)
+	}
+
+	in.N = 3
+	if _, err := Marshal(in); !errors.Is(err, ErrCountMismatch) {
+		t.Errorf("want=%v, out: %v", ErrCountMismatch, err)
+	}
+	if _, err := Marshal(3); err == nil {
+		t.Error("want=error for int, out: nil")
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"bytes"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -12,16 +11,16 @@ import (
 )
 
 const UtmpxFile = "/var/run/utmpx"
-const EntrySize = uint64(628)
 
 type Utmpx struct {
-	User []byte `binary:"256"`
-	Id   []byte `binary:"4"`
-	Line []byte `binary:"32"`
+	User string `binary:"256,cstring"`
+	Id   string `binary:"4,cstring"`
+	Line string `binary:"32,cstring"`
+	_    []byte `binary:"336"`
 }
 
 func (u *Utmpx) String() string {
-	return string(u.User) + " " + string(u.Id) + " " + string(u.Line)
+	return u.User + " " + u.Id + " " + u.Line
 }
 
 func main() {
@@ -37,26 +36,11 @@ func main() {
 	dataBuf := bytes.NewBuffer(data)
 	buf := bitstring.NewBuffer(dataBuf)
 
-	for {
-		data, err := buf.PopBytes(EntrySize)
-		if err != nil {
-			if err != io.EOF {
-				log.Fatalln("Error: ", err.Error())
-			}
-		}
-
-		b := bitstring.NewBuffer(bytes.NewBuffer(data))
-		u := &Utmpx{}
-
-		marshalErr := bitstring.Unmarshal(b, u)
-		if marshalErr != nil {
-			if marshalErr != io.EOF {
-				log.Fatalln("Error: ", err.Error())
-			}
-		}
+	var entries []Utmpx
+	if err := bitstring.NewDecoder(buf).DecodeAll(&entries); err != nil {
+		log.Fatalln("Error: ", err.Error())
+	}
+	for _, u := range entries {
 		fmt.Println(u.String())
-		if err == io.EOF {
-			break
-		}
 	}
 }
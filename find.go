@@ -0,0 +1,119 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"io"
+)
+
+// A matcher is the automaton of the Knuth-Morris-Pratt algorithm for a bit
+// pattern. Its state is the length of the longest prefix of the pattern
+// that ends the bits seen so far, and the pattern occurs when it reaches n.
+type matcher struct {
+	n     int
+	delta [][2]int // next state by state and bit.
+}
+
+func newMatcher(pattern BitString) *matcher {
+	m := &matcher{n: pattern.n, delta: make([][2]int, pattern.n+1)}
+	if m.n == 0 {
+		return m
+	}
+	bit := func(i int) int {
+		return int(pattern.bits(i, 1))
+	}
+	m.delta[0][bit(0)] = 1
+	x := 0 // state after the pattern without its first bit
+	for j := 1; j < m.n; j++ {
+		m.delta[j] = m.delta[x]
+		m.delta[j][bit(j)] = j + 1
+		x = m.delta[x][bit(j)]
+	}
+	// After a match, go on as after a mismatch to find overlapping ones.
+	m.delta[m.n] = m.delta[x]
+	return m
+}
+
+// Find returns the bit offsets of all occurrences of pattern in data, in
+// increasing order. Occurrences may overlap and start at any bit. The
+// empty pattern occurs at every offset.
+func Find(pattern BitString, data []byte) []int {
+	var offsets []int
+	if pattern.n == 0 {
+		for i := 0; i <= len(data)*8; i++ {
+			offsets = append(offsets, i)
+		}
+		return offsets
+	}
+	m := newMatcher(pattern)
+	state := 0
+	for i, c := range data {
+		for j := 0; j < 8; j++ {
+			state = m.delta[state][c>>uint(7-j)&1]
+			if state == m.n {
+				offsets = append(offsets, i*8+j+1-m.n)
+			}
+		}
+	}
+	return offsets
+}
+
+// ScanTo skips the bits of b up to the next occurrence of pattern, so that
+// the next pop returns its first bits, and returns the number of bits
+// skipped. Bits are read a byte at a time. If pattern does not occur in the
+// rest of b, all of it is skipped and io.EOF is returned. Skipped bits are
+// not recorded by DecodeValue or fed to running CRCs.
+func (b *Buffer) ScanTo(pattern BitString) (uint64, error) {
+	start := b.pos
+	if pattern.n == 0 {
+		return 0, nil
+	}
+	rec, crcs := b.rec, b.crcs
+	b.rec, b.crcs = nil, nil
+	defer func() { b.rec, b.crcs = rec, crcs }()
+
+	m := newMatcher(pattern)
+	state := 0
+	for {
+		eof, err := b.AtEOF()
+		if err != nil {
+			return b.pos - start, err
+		}
+		if eof {
+			return b.pos - start, io.EOF
+		}
+		// Pop up to the next byte border, so that the end of buf is never
+		// passed.
+		size := Uint8Size - b.pos%Uint8Size
+		if b.limited && b.limit-b.pos < size {
+			size = b.limit - b.pos
+		}
+		bin, err := b.PopUint8(size)
+		if err != nil && err != io.EOF {
+			return b.pos - start, err
+		}
+		for i := uint64(0); i < size; i++ {
+			state = m.delta[state][bin>>(size-1-i)&1]
+			if state == m.n {
+				var bld builder
+				bld.pushBits(pattern, 0, pattern.n)
+				bld.push(uint64(bin), int(size-1-i))
+				b.unpop(bld.bitString())
+				return b.pos - start, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	cases := []struct {
+		pattern string
+		data    string
+		want    []int
+	}{
+		{"0xfff", "0000 1111 1111 1111 0000", []int{4}},
+		{"0b0111_1110", "0111 1110 1011 1111 0011 1111 0000", []int{0, 9, 17}},
+		{"0b11", "0b1110_0000", []int{0, 1}},
+		{"0b101", "0b0000_0000", nil},
+		{"0b", "0xff", []int{0, 1, 2, 3, 4, 5, 6, 7, 8}},
+	}
+	for _, c := range cases {
+		out := Find(MustParseBitString(c.pattern), MustParseBitString(c.data).Bytes())
+		if !reflect.DeepEqual(c.want, out) {
+			t.Errorf("%s in %s: want=%v, out: %v", c.pattern, c.data, c.want, out)
+		}
+	}
+}
+
+func TestFindRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		data, _ := randomBits(r, 8*r.Intn(40))
+		pattern, _ := randomBits(r, 1+r.Intn(12))
+		var want []int
+		for j := 0; j+pattern.Len() <= data.Len(); j++ {
+			if data.Slice(j, j+pattern.Len()).Equal(pattern) {
+				want = append(want, j)
+			}
+		}
+		if out := Find(pattern, data.Bytes()); !reflect.DeepEqual(want, out) {
+			t.Errorf("%v in %v: want=%v, out: %v", pattern, data, want, out)
+		}
+	}
+}
+
+func TestScanTo(t *testing.T) {
+	// MPEG audio frames start with 12 set bits at no particular offset.
+	data := MustParseBitString("100 1111_1111_1111 0101, 0x3c, 0b1111_1111_1111_0").Bytes()
+	b := NewBuffer(bytes.NewBuffer(data))
+	sync := MustParseBitString("0xfff")
+
+	n, err := b.ScanTo(sync)
+	if err != nil || n != 3 {
+		t.Fatalf("want=3, out: %d, %v", n, err)
+	}
+	v, err := b.PopUint16(16)
+	if err != nil || v != 0xfff5 {
+		t.Errorf("want=%#x, out: %#x, %v", 0xfff5, v, err)
+	}
+	n, err = b.ScanTo(sync)
+	if err != nil || n != 8 || b.Pos() != 27 {
+		t.Fatalf("want=8 at 27, out: %d at %d, %v", n, b.Pos(), err)
+	}
+	if n, err = b.ScanTo(sync); err != nil || n != 0 {
+		t.Errorf("want=0, out: %d, %v", n, err)
+	}
+	v, err = b.PopUint16(13)
+	if err != io.EOF || v != 0x1ffe || b.Truncated() {
+		t.Errorf("want=%#x, out: %#x, %v", 0x1ffe, v, err)
+	}
+
+	b = NewBuffer(bytes.NewBuffer(data))
+	if n, err := b.ScanTo(MustParseBitString("0b0000_0000_0")); err != io.EOF || n != 40 || b.Truncated() {
+		t.Errorf("want=40 bits skipped, out: %d, %v", n, err)
+	}
+}
+
+func TestScanToLimited(t *testing.T) {
+	b := MustParseBitString("0b1001_0110_1").Reader()
+	n, err := b.ScanTo(MustParseBitString("0b1101"))
+	if err != nil || n != 5 {
+		t.Fatalf("want=5, out: %d, %v", n, err)
+	}
+	if eof, _ := b.AtEOF(); eof {
+		t.Error("want bits left")
+	}
+	v, err := b.PopUint8(8)
+	if err != io.EOF || v != 0xd || !b.Truncated() {
+		t.Errorf("want=%#x, out: %#x, %v", 0xd, v, err)
+	}
+
+	b = MustParseBitString("0b1001_0110_1").Reader()
+	if n, err := b.ScanTo(MustParseBitString("0b111")); err != io.EOF || n != 9 {
+		t.Errorf("want=9 bits skipped, out: %d, %v", n, err)
+	}
+}
@@ -0,0 +1,14 @@
+module github.com/ymotongpoo/go-bitstring
+
+go 1.25.0
+
+require (
+	golang.org/x/text v0.40.0
+	golang.org/x/tools v0.47.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	golang.org/x/mod v0.37.0 // indirect
+	golang.org/x/sync v0.22.0 // indirect
+)
@@ -0,0 +1,582 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package kaitai
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidExpr  = errors.New("bitarray: kaitai: invalid expression")
+	ErrExprType     = errors.New("bitarray: kaitai: operand of wrong type in expression")
+	ErrExprOverflow = errors.New("bitarray: kaitai: integer overflow in expression")
+)
+
+// An expr is a compiled Kaitai expression. Integers evaluate to int64, or
+// to uint64 if they are too large for int64, as u8 and b64 values can be.
+type expr interface {
+	eval(s *scope) (interface{}, error)
+}
+
+type (
+	literal struct{ v interface{} }
+	name    struct{ id string }
+	attr    struct {
+		x  expr
+		id string
+	}
+	unary struct {
+		op string
+		x  expr
+	}
+	binary struct {
+		op   string
+		x, y expr
+	}
+)
+
+// precedence of binary operators; higher binds tighter.
+var precedence = map[string]int{
+	"or": 1, "and": 2,
+	"==": 4, "!=": 4, "<": 4, "<=": 4, ">": 4, ">=": 4,
+	"|": 5, "^": 6, "&": 7, "<<": 8, ">>": 8,
+	"+": 9, "-": 9, "*": 10, "/": 10, "%": 10,
+}
+
+// parseExpr compiles src. Enum references such as ip_proto::tcp are
+// resolved against t.
+func parseExpr(src string, t *Spec) (expr, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, t: t}
+	x, err := p.binary(1)
+	if err != nil {
+		return nil, err
+	}
+	if p.i != len(p.toks) {
+		return nil, ErrInvalidExpr
+	}
+	return x, nil
+}
+
+func tokenize(src string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case isIdent(c) || c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (isIdent(src[j]) || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			toks = append(toks, src[i:j])
+			i = j
+		default:
+			if i+1 < len(src) {
+				switch op := src[i : i+2]; op {
+				case "==", "!=", "<=", ">=", "<<", ">>", "::":
+					toks = append(toks, op)
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("+-*/%&|^~<>().", rune(c)) {
+				return nil, ErrInvalidExpr
+			}
+			toks = append(toks, string(c))
+			i++
+		}
+	}
+	return toks, nil
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+type parser struct {
+	toks []string
+	i    int
+	t    *Spec
+}
+
+func (p *parser) peek() string {
+	if p.i < len(p.toks) {
+		return p.toks[p.i]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	tok := p.peek()
+	p.i++
+	return tok
+}
+
+// binary parses operators of at least precedence prec.
+func (p *parser) binary(prec int) (expr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		q, ok := precedence[op]
+		if !ok || q < prec {
+			return x, nil
+		}
+		p.next()
+		y, err := p.binary(q + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = binary{op, x, y}
+	}
+}
+
+func (p *parser) unary() (expr, error) {
+	switch p.peek() {
+	case "not":
+		p.next()
+		// not binds looser than comparisons.
+		x, err := p.binary(3)
+		if err != nil {
+			return nil, err
+		}
+		return unary{"not", x}, nil
+	case "-", "~":
+		op := p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unary{op, x}, nil
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (expr, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "." {
+		p.next()
+		id := p.next()
+		if len(id) == 0 || !isIdent(id[0]) {
+			return nil, ErrInvalidExpr
+		}
+		x = attr{x, id}
+	}
+	return x, nil
+}
+
+func (p *parser) primary() (expr, error) {
+	tok := p.next()
+	switch {
+	case tok == "(":
+		x, err := p.binary(1)
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, ErrInvalidExpr
+		}
+		return x, nil
+	case tok == "true" || tok == "false":
+		return literal{tok == "true"}, nil
+	case len(tok) > 0 && tok[0] >= '0' && tok[0] <= '9':
+		n, err := strconv.ParseUint(tok, 0, 64)
+		if err != nil {
+			return nil, ErrInvalidExpr
+		}
+		return literal{number(n)}, nil
+	case len(tok) > 0 && isIdent(tok[0]):
+		if p.peek() != "::" {
+			return name{tok}, nil
+		}
+		p.next()
+		id := p.next()
+		values, ok := p.t.enum(tok)
+		if !ok {
+			return nil, ErrUnknownEnum
+		}
+		for v, e := range values {
+			if e.ID == id {
+				return literal{v}, nil
+			}
+		}
+		return nil, ErrUnknownEnum
+	}
+	return nil, ErrInvalidExpr
+}
+
+func (x literal) eval(s *scope) (interface{}, error) { return x.v, nil }
+
+func (x name) eval(s *scope) (interface{}, error) {
+	switch x.id {
+	case "_":
+		return s.cur, nil
+	case "_io":
+		return ioValue{s.b}, nil
+	case "_parent":
+		if s.parent == nil {
+			return nil, ErrInvalidExpr
+		}
+		return s.parent, nil
+	case "_root":
+		for s.parent != nil {
+			s = s.parent
+		}
+		return s, nil
+	}
+	return s.get(x.id)
+}
+
+func (x attr) eval(s *scope) (interface{}, error) {
+	v, err := x.x.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case *scope:
+		return v.get(x.id)
+	case ioValue:
+		switch x.id {
+		case "eof":
+			return v.b.AtEOF()
+		case "pos":
+			return int64(v.b.Pos() / 8), nil
+		}
+	case []byte:
+		if x.id == "size" || x.id == "length" {
+			return int64(len(v)), nil
+		}
+	case string:
+		if x.id == "length" {
+			return int64(len([]rune(v))), nil
+		}
+	case []interface{}:
+		switch x.id {
+		case "size", "length":
+			return int64(len(v)), nil
+		case "first", "last":
+			if len(v) == 0 {
+				return nil, ErrInvalidExpr
+			}
+			if x.id == "first" {
+				return v[0], nil
+			}
+			return v[len(v)-1], nil
+		}
+	}
+	return nil, ErrExprType
+}
+
+func (x unary) eval(s *scope) (interface{}, error) {
+	v, err := x.x.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	if x.op == "not" {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, ErrExprType
+		}
+		return !b, nil
+	}
+	n, ok := toInteger(v)
+	if !ok {
+		return nil, ErrExprType
+	}
+	if x.op == "-" {
+		n.neg = !n.neg && n.mag != 0
+		return n.value()
+	}
+	if n.neg {
+		// ^n is -n-1, which is not negative.
+		return number(n.mag - 1), nil
+	}
+	return n.complement()
+}
+
+func (x binary) eval(s *scope) (interface{}, error) {
+	a, err := x.x.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	if x.op == "and" || x.op == "or" {
+		ab, ok := a.(bool)
+		if !ok {
+			return nil, ErrExprType
+		}
+		if ab == (x.op == "or") {
+			return ab, nil
+		}
+		b, err := x.y.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		bb, ok := b.(bool)
+		if !ok {
+			return nil, ErrExprType
+		}
+		return bb, nil
+	}
+	b, err := x.y.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "==", "!=":
+		eq, err := equal(a, b)
+		if err != nil {
+			return nil, err
+		}
+		return eq == (x.op == "=="), nil
+	}
+	m, ok1 := toInteger(a)
+	n, ok2 := toInteger(b)
+	if !ok1 || !ok2 {
+		return nil, ErrExprType
+	}
+	switch x.op {
+	case "<":
+		return m.cmp(n) < 0, nil
+	case "<=":
+		return m.cmp(n) <= 0, nil
+	case ">":
+		return m.cmp(n) > 0, nil
+	case ">=":
+		return m.cmp(n) >= 0, nil
+	case "+":
+		return m.add(n)
+	case "-":
+		n.neg = !n.neg && n.mag != 0
+		return m.add(n)
+	case "*":
+		hi, lo := bits.Mul64(m.mag, n.mag)
+		if hi != 0 {
+			return nil, ErrExprOverflow
+		}
+		return integer{m.neg != n.neg && lo != 0, lo}.value()
+	case "/", "%":
+		if n.mag == 0 {
+			return nil, ErrInvalidExpr
+		}
+		// Division truncates toward zero, as in Go.
+		if x.op == "/" {
+			q := m.mag / n.mag
+			return integer{m.neg != n.neg && q != 0, q}.value()
+		}
+		r := m.mag % n.mag
+		return integer{m.neg && r != 0, r}.value()
+	}
+	// Bitwise operators work on 64 bit two's complement integers.
+	u, err := m.bits()
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "&", "|", "^":
+		v, err := n.bits()
+		if err != nil {
+			return nil, err
+		}
+		// The result is negative if its bits past the 64th are set.
+		neg := false
+		switch x.op {
+		case "&":
+			u, neg = u&v, m.neg && n.neg
+		case "|":
+			u, neg = u|v, m.neg || n.neg
+		default:
+			u, neg = u^v, m.neg != n.neg
+		}
+		return fromBits(u, neg)
+	case "<<", ">>":
+		if n.neg || n.mag > 63 {
+			return nil, ErrInvalidExpr
+		}
+		if x.op == ">>" {
+			if m.neg {
+				return int64(u) >> n.mag, nil
+			}
+			return number(u >> n.mag), nil
+		}
+		if m.neg {
+			if int64(u)<<n.mag>>n.mag != int64(u) {
+				return nil, ErrExprOverflow
+			}
+			return int64(u) << n.mag, nil
+		}
+		if (u<<n.mag)>>n.mag != u {
+			return nil, ErrExprOverflow
+		}
+		return number(u << n.mag), nil
+	}
+	return nil, ErrInvalidExpr
+}
+
+func equal(a, b interface{}) (bool, error) {
+	if m, ok := toInteger(a); ok {
+		n, ok := toInteger(b)
+		return ok && m.cmp(n) == 0, nil
+	}
+	switch a := a.(type) {
+	case bool, string:
+		return a == b, nil
+	case []byte:
+		if b, ok := b.([]byte); ok {
+			return string(a) == string(b), nil
+		}
+	}
+	return false, ErrExprType
+}
+
+// number returns v as an int64 if it fits, and as a uint64 otherwise.
+func number(v uint64) interface{} {
+	if v <= math.MaxInt64 {
+		return int64(v)
+	}
+	return v
+}
+
+// An integer is an integer value of an expression, as a sign and a
+// magnitude, so that uint64 and int64 values mix without wrapping around.
+type integer struct {
+	neg bool
+	mag uint64
+}
+
+// toInteger returns the integer v, which is an int64 or a uint64.
+func toInteger(v interface{}) (integer, bool) {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return integer{true, -uint64(v)}, true
+		}
+		return integer{false, uint64(v)}, true
+	case uint64:
+		return integer{false, v}, true
+	}
+	return integer{}, false
+}
+
+// value returns n as an int64 if it fits, and as a uint64 otherwise.
+func (n integer) value() (interface{}, error) {
+	if !n.neg {
+		return number(n.mag), nil
+	}
+	if n.mag > 1<<63 {
+		return nil, ErrExprOverflow
+	}
+	return int64(-n.mag), nil
+}
+
+func (n integer) cmp(o integer) int {
+	switch {
+	case n.neg != o.neg && n.neg:
+		return -1
+	case n.neg != o.neg:
+		return 1
+	case n.mag == o.mag:
+		return 0
+	case (n.mag < o.mag) != n.neg:
+		return -1
+	}
+	return 1
+}
+
+func (n integer) add(o integer) (interface{}, error) {
+	if n.neg == o.neg {
+		sum, carry := bits.Add64(n.mag, o.mag, 0)
+		if carry != 0 {
+			return nil, ErrExprOverflow
+		}
+		return integer{n.neg, sum}.value()
+	}
+	if n.mag >= o.mag {
+		return integer{n.neg && n.mag != o.mag, n.mag - o.mag}.value()
+	}
+	return integer{o.neg, o.mag - n.mag}.value()
+}
+
+// bits returns n as a 64 bit two's complement integer.
+func (n integer) bits() (uint64, error) {
+	if n.neg && n.mag > 1<<63 {
+		return 0, ErrExprOverflow
+	}
+	if n.neg {
+		return -n.mag, nil
+	}
+	return n.mag, nil
+}
+
+// complement returns ^n of a non-negative n. It is negative if n fits in
+// an int64, and the complement of a uint64 otherwise.
+func (n integer) complement() (interface{}, error) {
+	if n.mag <= math.MaxInt64 {
+		return ^int64(n.mag), nil
+	}
+	return number(^n.mag), nil
+}
+
+// fromBits returns the 64 bits u of the result of a bitwise operation,
+// which is negative if neg is set.
+func fromBits(u uint64, neg bool) (interface{}, error) {
+	if !neg {
+		return number(u), nil
+	}
+	if int64(u) >= 0 {
+		return nil, ErrExprOverflow
+	}
+	return int64(u), nil
+}
+
+// evalInt evaluates x to a non-negative integer.
+func evalInt(x expr, s *scope) (uint64, error) {
+	v, err := x.eval(s)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := toInteger(v)
+	if !ok || n.neg {
+		return 0, ErrExprType
+	}
+	return n.mag, nil
+}
+
+// evalBool evaluates x to a boolean.
+func evalBool(x expr, s *scope) (bool, error) {
+	v, err := x.eval(s)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, ErrExprType
+	}
+	return b, nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package kaitai
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	bitstring "github.com/ymotongpoo/go-bitstring"
+)
+
+func TestExpr(t *testing.T) {
+	spec := &Spec{Enums: map[string]map[int64]EnumValue{"color": {2: {ID: "red"}}}}
+	b := bitstring.NewBuffer(bytes.NewReader([]byte{0x01}))
+	s := &scope{
+		b: b,
+		vals: map[string]interface{}{
+			"n":    int64(6),
+			"big":  uint64(1<<63 + 5),
+			"flag": true,
+			"data": []byte{1, 2, 3},
+			"list": []interface{}{int64(4), int64(5)},
+		},
+	}
+	cases := []struct {
+		src  string
+		want interface{}
+	}{
+		{"1 + 2 * 3", int64(7)},
+		{"(1 + 2) * 3", int64(9)},
+		{"n - 2 - 1", int64(3)},
+		{"n << 1 | 1", int64(13)},
+		{"n & 0x3 == 2", true},
+		{"-n % 4", int64(-2)},
+		{"~0", int64(-1)},
+		{"not flag or n > 5", true},
+		{"not n == 6", false},
+		{"flag and n != 6", false},
+		{"data.size + list.length", int64(5)},
+		{"list.last", int64(5)},
+		{"n - 4 == color::red", true},
+		{"_io.eof", false},
+		{"_io.pos", int64(0)},
+		{"0b101", int64(5)},
+		{"big > n", true},
+		{"-1 < big", true},
+		{"big == 0x8000000000000005", true},
+		{"big - 0x8000000000000006", int64(-1)},
+		{"big - n + 1", uint64(1 << 63)},
+		{"big % 16 + big / big", int64(6)},
+		{"big >> 60", int64(8)},
+		{"big & -1", uint64(1<<63 + 5)},
+		{"~big", int64(1<<63 - 6)},
+		{"-0x8000000000000000", int64(-1 << 63)},
+		{"0xffffffffffffffff", uint64(1<<64 - 1)},
+	}
+	for _, c := range cases {
+		x, err := parseExpr(c.src, spec)
+		if err != nil {
+			t.Errorf("%s: %v", c.src, err)
+			continue
+		}
+		out, err := x.eval(s)
+		if err != nil {
+			t.Errorf("%s: %v", c.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(c.want, out) {
+			t.Errorf("%s: want=%#v, out: %#v", c.src, c.want, out)
+		}
+	}
+
+	errs := []struct {
+		src  string
+		want error
+	}{
+		{"n +", ErrInvalidExpr},
+		{"(n", ErrInvalidExpr},
+		{"n $ 1", ErrInvalidExpr},
+		{"color::blue", ErrUnknownEnum},
+	}
+	for _, c := range errs {
+		if _, err := parseExpr(c.src, spec); err != c.want {
+			t.Errorf("%s: want=%v, out: %v", c.src, c.want, err)
+		}
+	}
+	evalErrs := []struct {
+		src  string
+		want error
+	}{
+		{"flag + 1", ErrExprType},
+		{"missing", ErrUnknownName},
+		{"n / 0", ErrInvalidExpr},
+		{"_parent", ErrInvalidExpr},
+		{"big + big", ErrExprOverflow},
+		{"big * 2", ErrExprOverflow},
+		{"-big", ErrExprOverflow},
+		{"big << 1", ErrExprOverflow},
+		{"big ^ -1", ErrExprOverflow},
+	}
+	for _, c := range evalErrs {
+		x, err := parseExpr(c.src, spec)
+		if err != nil {
+			t.Errorf("%s: %v", c.src, err)
+			continue
+		}
+		if _, err := x.eval(s); err != c.want {
+			t.Errorf("%s: want=%v, out: %v", c.src, c.want, err)
+		}
+	}
+}
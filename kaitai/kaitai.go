@@ -0,0 +1,651 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// Package kaitai decodes data described by Kaitai Struct definitions
+// (.ksy files). It supports the subset of the language that covers most bit
+// level formats:
+//
+//   - meta: id, endian, encoding and bit-endian (be only)
+//   - seq attributes with id, type, size, size-eos, contents, encoding,
+//     repeat (eos, expr, until), if and enum
+//   - types u1-u8, s1-s8, f4, f8 with le/be suffixes, bN, str, strz, and
+//     user types from types, looked up in enclosing types as well
+//   - enums
+//
+// Expressions support integer and boolean literals, field names, _, _io.eof,
+// _io.pos, _parent, _root, enum references like ip_proto::tcp, attributes
+// .size, .length, .first and .last, and the arithmetic, bitwise, comparison
+// and logical operators. Instances, switch-on types, process and
+// parameters are not supported.
+package kaitai
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	bitstring "github.com/ymotongpoo/go-bitstring"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUnsupported = errors.New("bitarray: kaitai: unsupported construct")
+	ErrUnknownType = errors.New("bitarray: kaitai: unknown type")
+	ErrUnknownEnum = errors.New("bitarray: kaitai: unknown enum or enum value")
+	ErrUnknownName = errors.New("bitarray: kaitai: expression refers to a field not decoded")
+	ErrNoEndian    = errors.New("bitarray: kaitai: endianness of multi-byte type is not given")
+	ErrNoSize      = errors.New("bitarray: kaitai: attribute needs size or size-eos")
+	ErrContents    = errors.New("bitarray: kaitai: data does not match contents")
+)
+
+// A Spec is a Kaitai Struct type: the top level of a .ksy file or an entry
+// of its types.
+type Spec struct {
+	Meta  Meta                           `yaml:"meta"`
+	Seq   []Attr                         `yaml:"seq"`
+	Types map[string]*Spec               `yaml:"types"`
+	Enums map[string]map[int64]EnumValue `yaml:"enums"`
+
+	parent   *Spec
+	endian   string // endianness, inherited from enclosing types
+	encoding string // default string encoding, inherited from enclosing types
+}
+
+// Meta is the meta section of a Spec.
+type Meta struct {
+	ID        string `yaml:"id"`
+	Endian    string `yaml:"endian"`
+	BitEndian string `yaml:"bit-endian"`
+	Encoding  string `yaml:"encoding"`
+}
+
+// An EnumValue names a value of an enum. It is written either as a plain
+// name or as a map with an id key.
+type EnumValue struct {
+	ID string `yaml:"id"`
+}
+
+func (e *EnumValue) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		e.ID = n.Value
+		return nil
+	}
+	type plain EnumValue
+	return n.Decode((*plain)(e))
+}
+
+// An Attr is an attribute in the seq of a Spec.
+type Attr struct {
+	ID          string      `yaml:"id"`
+	Type        string      `yaml:"type"`
+	Size        string      `yaml:"size"`
+	SizeEOS     bool        `yaml:"size-eos"`
+	Contents    interface{} `yaml:"contents"`
+	Encoding    string      `yaml:"encoding"`
+	Repeat      string      `yaml:"repeat"`
+	RepeatExpr  string      `yaml:"repeat-expr"`
+	RepeatUntil string      `yaml:"repeat-until"`
+	If          string      `yaml:"if"`
+	Enum        string      `yaml:"enum"`
+
+	kind     attrKind
+	width    int  // bytes of integer and float types, bits of bN types
+	le       bool // whether integer and float types are little endian
+	elem     *Spec
+	size     expr
+	cond     expr
+	count    expr
+	until    expr
+	contents []byte
+	enum     map[int64]EnumValue
+	encoding string // encoding of strings, or the default of the type
+}
+
+type attrKind int
+
+const (
+	kindBytes attrKind = iota
+	kindUint
+	kindSint
+	kindFloat
+	kindBits
+	kindBool
+	kindStr
+	kindStrz
+	kindUser
+)
+
+var (
+	intType   = regexp.MustCompile(`^([us])([1248])(le|be)?$`)
+	floatType = regexp.MustCompile(`^f([48])(le|be)?$`)
+	bitsType  = regexp.MustCompile(`^b([0-9]+)$`)
+)
+
+// Parse parses a .ksy document.
+func Parse(data []byte) (*Spec, error) {
+	var t Spec
+	if err := yaml.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	t.link(nil)
+	if err := t.compile(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Load reads a .ksy file.
+func Load(name string) (*Spec, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// link sets the enclosing type and inherited meta data of t and its types.
+func (t *Spec) link(parent *Spec) {
+	t.parent = parent
+	t.endian, t.encoding = t.Meta.Endian, t.Meta.Encoding
+	if parent != nil {
+		if len(t.endian) == 0 {
+			t.endian = parent.endian
+		}
+		if len(t.encoding) == 0 {
+			t.encoding = parent.encoding
+		}
+	}
+	for _, u := range t.Types {
+		u.link(t)
+	}
+}
+
+// lookupType finds the user type name in t or its enclosing types.
+func (t *Spec) lookupType(name string) *Spec {
+	for ; t != nil; t = t.parent {
+		if u, ok := t.Types[name]; ok {
+			return u
+		}
+	}
+	return nil
+}
+
+// enum finds the enum name in t or its enclosing types.
+func (t *Spec) enum(name string) (map[int64]EnumValue, bool) {
+	for ; t != nil; t = t.parent {
+		if e, ok := t.Enums[name]; ok {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// compile checks the attributes of t and its types and compiles their
+// expressions.
+func (t *Spec) compile() error {
+	switch t.Meta.BitEndian {
+	case "", "be":
+	default:
+		return &bitstring.FieldError{Field: "meta", Err: ErrUnsupported}
+	}
+	switch t.endian {
+	case "", "le", "be":
+	default:
+		return &bitstring.FieldError{Field: "meta", Err: ErrUnsupported}
+	}
+	for i := range t.Seq {
+		a := &t.Seq[i]
+		if err := a.compile(t); err != nil {
+			return &bitstring.FieldError{Field: a.ID, Err: err}
+		}
+	}
+	for _, u := range t.Types {
+		if err := u.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Attr) compile(t *Spec) error {
+	var err error
+	if len(a.ID) == 0 {
+		return ErrUnsupported
+	}
+	a.encoding = a.Encoding
+	if len(a.encoding) == 0 {
+		a.encoding = t.encoding
+	}
+	if len(a.Size) != 0 {
+		if a.size, err = parseExpr(a.Size, t); err != nil {
+			return err
+		}
+	}
+	if len(a.If) != 0 {
+		if a.cond, err = parseExpr(a.If, t); err != nil {
+			return err
+		}
+	}
+	switch a.Repeat {
+	case "", "eos":
+	case "expr":
+		if a.count, err = parseExpr(a.RepeatExpr, t); err != nil {
+			return err
+		}
+	case "until":
+		if a.until, err = parseExpr(a.RepeatUntil, t); err != nil {
+			return err
+		}
+	default:
+		return ErrUnsupported
+	}
+	if a.Contents != nil {
+		if a.contents, err = contents(a.Contents); err != nil {
+			return err
+		}
+		if len(a.Type) != 0 || a.size != nil || a.SizeEOS {
+			return ErrUnsupported
+		}
+		a.size = literal{int64(len(a.contents))}
+	}
+
+	if m := intType.FindStringSubmatch(a.Type); m != nil {
+		a.kind = kindUint
+		if m[1] == "s" {
+			a.kind = kindSint
+		}
+		a.width, _ = strconv.Atoi(m[2])
+		if err := a.endian(t, m[3]); err != nil {
+			return err
+		}
+	} else if m := floatType.FindStringSubmatch(a.Type); m != nil {
+		a.kind = kindFloat
+		a.width, _ = strconv.Atoi(m[1])
+		if err := a.endian(t, m[2]); err != nil {
+			return err
+		}
+	} else if m := bitsType.FindStringSubmatch(a.Type); m != nil {
+		a.kind = kindBits
+		a.width, _ = strconv.Atoi(m[1])
+		if a.width == 0 || a.width > 64 {
+			return bitstring.ErrFieldSizeTooLarge
+		}
+		if a.width == 1 {
+			a.kind = kindBool
+		}
+	} else {
+		switch a.Type {
+		case "":
+			a.kind = kindBytes
+			if a.size == nil && !a.SizeEOS {
+				return ErrNoSize
+			}
+		case "str":
+			a.kind = kindStr
+			if a.size == nil && !a.SizeEOS {
+				return ErrNoSize
+			}
+		case "strz":
+			a.kind = kindStrz
+		default:
+			a.kind = kindUser
+			if a.elem = t.lookupType(a.Type); a.elem == nil {
+				return ErrUnknownType
+			}
+		}
+	}
+
+	if len(a.Enum) != 0 {
+		if a.kind != kindUint && a.kind != kindSint && a.kind != kindBits {
+			return ErrUnsupported
+		}
+		var ok bool
+		if a.enum, ok = t.enum(a.Enum); !ok {
+			return ErrUnknownEnum
+		}
+	}
+	if (a.size != nil || a.SizeEOS) && a.kind != kindBytes && a.kind != kindStr &&
+		a.kind != kindStrz && a.kind != kindUser {
+		return ErrUnsupported
+	}
+	return nil
+}
+
+// endian sets the byte order of a multi-byte type from its suffix or the
+// endianness of t.
+func (a *Attr) endian(t *Spec, suffix string) error {
+	if len(suffix) == 0 {
+		suffix = t.endian
+	}
+	if len(suffix) == 0 && a.width > 1 {
+		return ErrNoEndian
+	}
+	a.le = suffix == "le"
+	return nil
+}
+
+// contents converts a contents key, a string or a list of bytes and
+// strings, to bytes.
+func contents(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case string:
+		return []byte(v), nil
+	case []interface{}:
+		var data []byte
+		for _, e := range v {
+			switch e := e.(type) {
+			case int:
+				if e < 0 || e > 0xff {
+					return nil, ErrUnsupported
+				}
+				data = append(data, byte(e))
+			case string:
+				data = append(data, e...)
+			default:
+				return nil, ErrUnsupported
+			}
+		}
+		return data, nil
+	}
+	return nil, ErrUnsupported
+}
+
+// A scope holds the attributes of a type decoded so far.
+type scope struct {
+	b      *bitstring.Buffer
+	parent *scope
+	vals   map[string]interface{} // values as seen by expressions
+	out    map[string]interface{} // values as returned by Decode
+	cur    interface{}            // element checked by repeat-until
+}
+
+func (s *scope) get(id string) (interface{}, error) {
+	v, ok := s.vals[id]
+	if !ok {
+		return nil, ErrUnknownName
+	}
+	return v, nil
+}
+
+// ioValue is the value of _io in expressions.
+type ioValue struct {
+	b *bitstring.Buffer
+}
+
+// Decode decodes a value of type t from b into a map from attribute ids to
+// values. Values are uint64 for unsigned and bit types, int64 for signed
+// types, float64, bool for b1, []byte, string, the name of the member for
+// enums (or the number if it is not a member), map[string]interface{} for
+// user types and []interface{} for repeated attributes. Attributes whose if
+// expression is false are left out.
+func (t *Spec) Decode(b *bitstring.Buffer) (map[string]interface{}, error) {
+	s, err := t.decode(b, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.out, nil
+}
+
+func (t *Spec) decode(b *bitstring.Buffer, parent *scope) (*scope, error) {
+	s := &scope{
+		b:      b,
+		parent: parent,
+		vals:   make(map[string]interface{}),
+		out:    make(map[string]interface{}),
+	}
+	for i := range t.Seq {
+		a := &t.Seq[i]
+		if err := a.decode(s); err != nil {
+			if _, ok := err.(*bitstring.FieldError); ok {
+				return nil, err
+			}
+			return nil, &bitstring.FieldError{Field: a.ID, Err: err}
+		}
+	}
+	return s, nil
+}
+
+// decode decodes a into s.
+func (a *Attr) decode(s *scope) error {
+	if a.cond != nil {
+		ok, err := evalBool(a.cond, s)
+		if err != nil || !ok {
+			return err
+		}
+	}
+	if len(a.Repeat) == 0 {
+		out, val, err := a.read(s)
+		if err != nil {
+			return err
+		}
+		s.out[a.ID], s.vals[a.ID] = out, val
+		return nil
+	}
+
+	outs, vals := []interface{}{}, []interface{}{}
+	for n := uint64(0); ; n++ {
+		switch a.Repeat {
+		case "eos":
+			eof, err := s.b.AtEOF()
+			if err != nil {
+				return err
+			}
+			if eof {
+				s.out[a.ID], s.vals[a.ID] = outs, vals
+				return nil
+			}
+		case "expr":
+			count, err := evalInt(a.count, s)
+			if err != nil {
+				return err
+			}
+			if n == count {
+				s.out[a.ID], s.vals[a.ID] = outs, vals
+				return nil
+			}
+		}
+		pos := s.b.Pos()
+		out, val, err := a.read(s)
+		if err != nil {
+			return err
+		}
+		if a.Repeat == "eos" && s.b.Pos() == pos {
+			return bitstring.ErrNoProgress
+		}
+		outs, vals = append(outs, out), append(vals, val)
+		if a.Repeat == "until" {
+			s.cur = val
+			done, err := evalBool(a.until, s)
+			if err != nil {
+				return err
+			}
+			if done {
+				s.out[a.ID], s.vals[a.ID] = outs, vals
+				return nil
+			}
+		}
+	}
+}
+
+// read reads one value of a. It returns the value for Decode and the value
+// for expressions.
+func (a *Attr) read(s *scope) (interface{}, interface{}, error) {
+	b := s.b
+	switch a.kind {
+	case kindBits, kindBool:
+		v, err := b.PopUint64(uint64(a.width))
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		if b.Truncated() {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		if a.kind == kindBool {
+			return v != 0, v != 0, nil
+		}
+		return a.enumOut(v, number(v)), number(v), nil
+	case kindUint, kindSint, kindFloat:
+		data, err := readBytes(b, uint64(a.width))
+		if err != nil {
+			return nil, nil, err
+		}
+		var v uint64
+		for i := range data {
+			if a.le {
+				v |= uint64(data[i]) << (8 * uint(i))
+			} else {
+				v = v<<8 | uint64(data[i])
+			}
+		}
+		switch a.kind {
+		case kindSint:
+			shift := uint(64 - 8*a.width)
+			n := int64(v<<shift) >> shift
+			return a.enumOut(n, n), n, nil
+		case kindFloat:
+			f := math.Float64frombits(v)
+			if a.width == 4 {
+				f = float64(math.Float32frombits(uint32(v)))
+			}
+			return f, f, nil
+		}
+		return a.enumOut(v, number(v)), number(v), nil
+	case kindStrz:
+		if a.size == nil && !a.SizeEOS {
+			if err := b.Align(); err != nil {
+				return nil, nil, err
+			}
+			data := []byte{}
+			for {
+				c, err := readBytes(b, 1)
+				if err != nil {
+					return nil, nil, err
+				}
+				if c[0] == 0 {
+					break
+				}
+				data = append(data, c[0])
+			}
+			return a.str(data)
+		}
+	}
+
+	var data []byte
+	var err error
+	if a.SizeEOS {
+		data, err = readEOS(b)
+	} else if a.size != nil {
+		var n uint64
+		if n, err = evalInt(a.size, s); err != nil {
+			return nil, nil, err
+		}
+		data, err = readBytes(b, n)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	switch a.kind {
+	case kindStr:
+		return a.str(data)
+	case kindStrz:
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			data = data[:i]
+		}
+		return a.str(data)
+	case kindUser:
+		sub := b
+		if data != nil {
+			sub = bitstring.NewBuffer(bytes.NewReader(data))
+		}
+		u, err := a.elem.decode(sub, s)
+		if err != nil {
+			return nil, nil, err
+		}
+		return u.out, u, nil
+	}
+	if a.contents != nil && !bytes.Equal(data, a.contents) {
+		return nil, nil, ErrContents
+	}
+	return data, data, nil
+}
+
+// enumOut returns the member name of the expression value v if a is an
+// enum with such a member, and out otherwise.
+func (a *Attr) enumOut(out interface{}, v interface{}) interface{} {
+	n, ok := v.(int64)
+	if !ok {
+		return out
+	}
+	if e, ok := a.enum[n]; ok {
+		return e.ID
+	}
+	return out
+}
+
+// str decodes data in the encoding of a.
+func (a *Attr) str(data []byte) (interface{}, interface{}, error) {
+	enc := a.encoding
+	switch strings.ToLower(enc) {
+	case "", "utf-8", "utf8", "ascii":
+		enc = ""
+	}
+	str, err := bitstring.DecodeCharset(enc, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return str, str, nil
+}
+
+// readBytes reads n bytes from the next byte border of b.
+func readBytes(b *bitstring.Buffer, n uint64) ([]byte, error) {
+	if err := b.Align(); err != nil {
+		return nil, err
+	}
+	data, err := b.PopBytes(n)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if b.Truncated() || uint64(len(data)) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+// readEOS reads the bytes from the next byte border of b to its end.
+func readEOS(b *bitstring.Buffer) ([]byte, error) {
+	if err := b.Align(); err != nil {
+		return nil, err
+	}
+	data := []byte{}
+	for {
+		eof, err := b.AtEOF()
+		if err != nil {
+			return nil, err
+		}
+		if eof {
+			return data, nil
+		}
+		c, err := b.PopUint8(bitstring.Uint8Size)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		data = append(data, c)
+	}
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package kaitai
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	bitstring "github.com/ymotongpoo/go-bitstring"
+)
+
+const packet = `
+meta:
+  id: packet
+  endian: be
+seq:
+  - id: magic
+    contents: [0xca, 0xfe]
+  - id: version
+    type: b4
+  - id: ihl
+    type: b4
+  - id: flags
+    type: b3
+  - id: urgent
+    type: b1
+  - id: proto
+    type: b4
+    enum: ip_proto
+  - id: len
+    type: u2le
+  - id: n
+    type: u1
+  - id: options
+    type: option
+    repeat: expr
+    repeat-expr: n
+  - id: extra
+    type: s2
+    if: version == 4
+  - id: skipped
+    type: u1
+    if: version != 4
+  - id: name
+    type: strz
+    encoding: ASCII
+  - id: words
+    type: u1
+    repeat: until
+    repeat-until: _ == 0
+  - id: tail
+    size-eos: true
+types:
+  option:
+    seq:
+      - id: kind
+        type: u1
+        enum: opt_kind
+      - id: len
+        type: u1
+      - id: body
+        size: len
+enums:
+  ip_proto:
+    1: icmp
+    6: tcp
+  opt_kind:
+    0: eol
+    1:
+      id: nop
+`
+
+func decode(t *testing.T, ksy string, data []byte) (map[string]interface{}, error) {
+	spec, err := Parse([]byte(ksy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spec.Decode(bitstring.NewBuffer(bytes.NewReader(data)))
+}
+
+// Decode: Case 1) Bit fields, byte types, user types, repeats, if and enums.
+func TestDecodeCase1(t *testing.T) {
+	data := []byte{
+		0xca, 0xfe, // magic
+		0x45,       // 0100,0101
+		0xb6,       // 101,1,0110
+		0x34, 0x12, // len
+		0x02,       // n
+		0x01, 0x00, // nop
+		0x07, 0x02, 0xaa, 0xbb, // unknown option
+		0xff, 0xfe, // extra
+		'h', 'i', 0x00,
+		0x05, 0x00,
+		0x01, 0x02, 0x03,
+	}
+	out, err := decode(t, packet, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"magic":   []byte{0xca, 0xfe},
+		"version": uint64(4),
+		"ihl":     uint64(5),
+		"flags":   uint64(5),
+		"urgent":  true,
+		"proto":   "tcp",
+		"len":     uint64(0x1234),
+		"n":       uint64(2),
+		"options": []interface{}{
+			map[string]interface{}{"kind": "nop", "len": uint64(0), "body": []byte{}},
+			map[string]interface{}{"kind": uint64(7), "len": uint64(2), "body": []byte{0xaa, 0xbb}},
+		},
+		"extra": int64(-2),
+		"name":  "hi",
+		"words": []interface{}{uint64(5), uint64(0)},
+		"tail":  []byte{0x01, 0x02, 0x03},
+	}
+	if !reflect.DeepEqual(want, out) {
+		t.Errorf("want=%#v, out: %#v", want, out)
+	}
+}
+
+// Decode: Case 2) Sized user types are decoded from their own bytes, and
+// refer to enclosing values with _parent and _root.
+func TestDecodeCase2(t *testing.T) {
+	const ksy = `
+meta: {id: records}
+seq:
+  - {id: wide, type: b1}
+  - {id: recs, type: rec, size: 2, repeat: eos}
+types:
+  rec:
+    seq:
+      - {id: a, type: b4}
+      - {id: b, type: b4}
+      - {id: c, type: u1, if: _root.wide and _parent.wide}
+`
+	out, err := decode(t, ksy, []byte{0x80, 0x12, 0xff, 0x34, 0xee})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"wide": true,
+		"recs": []interface{}{
+			map[string]interface{}{"a": uint64(1), "b": uint64(2), "c": uint64(0xff)},
+			map[string]interface{}{"a": uint64(3), "b": uint64(4), "c": uint64(0xee)},
+		},
+	}
+	if !reflect.DeepEqual(want, out) {
+		t.Errorf("want=%#v, out: %#v", want, out)
+	}
+}
+
+// Decode: Case 3) Data that does not fit the definition is reported.
+func TestDecodeCase3(t *testing.T) {
+	cases := []struct {
+		data []byte
+		want error
+	}{
+		{[]byte{0xca, 0xff}, ErrContents},
+		{[]byte{0xca, 0xfe, 0x45, 0xb6, 0x34}, io.ErrUnexpectedEOF},
+		{[]byte{0xca, 0xfe, 0x45, 0xb6, 0x34, 0x12, 0x01, 0x07, 0x09}, io.ErrUnexpectedEOF},
+	}
+	for _, c := range cases {
+		_, err := decode(t, packet, c.data)
+		if !errors.Is(err, c.want) {
+			t.Errorf("%x: want=%v, out: %v", c.data, c.want, err)
+		}
+	}
+}
+
+// Decode: Case 4) u8 and b64 values past the range of int64 keep their
+// value in expressions.
+func TestDecodeCase4(t *testing.T) {
+	const ksy = `
+meta: {id: wide, endian: be}
+seq:
+  - {id: a, type: u8}
+  - {id: b, type: b64}
+  - {id: big, type: u1, if: a > 0x7fffffffffffffff and a == b}
+  - {id: rest, size: a - 0xfffffffffffffffe}
+`
+	data := append(bytes.Repeat([]byte{0xff}, 16), 0x01, 0x02)
+	out, err := decode(t, ksy, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"a":    uint64(1<<64 - 1),
+		"b":    uint64(1<<64 - 1),
+		"big":  uint64(1),
+		"rest": []byte{0x02},
+	}
+	if !reflect.DeepEqual(want, out) {
+		t.Errorf("want=%#v, out: %#v", want, out)
+	}
+}
+
+// Parse: Invalid definitions are reported with the offending attribute.
+func TestParse(t *testing.T) {
+	cases := []struct {
+		ksy  string
+		id   string
+		want error
+	}{
+		{"seq: [{id: a, type: foo}]", "a", ErrUnknownType},
+		{"seq: [{id: a, type: u2}]", "a", ErrNoEndian},
+		{"seq: [{id: a, type: u1, enum: e}]", "a", ErrUnknownEnum},
+		{"seq: [{id: a, type: b65}]", "a", bitstring.ErrFieldSizeTooLarge},
+		{"seq: [{id: a, type: str}]", "a", ErrNoSize},
+		{"seq: [{id: a, type: u1, if: a ==}]", "a", ErrInvalidExpr},
+		{"seq: [{id: a, type: u1, if: e::x}]\nenums: {e: {1: y}}", "a", ErrUnknownEnum},
+		{"seq: [{id: a, type: u1, repeat: forever}]", "a", ErrUnsupported},
+		{"meta: {bit-endian: le}", "meta", ErrUnsupported},
+		{"types: {t: {seq: [{id: b, type: t2}]}}", "b", ErrUnknownType},
+	}
+	for _, c := range cases {
+		_, err := Parse([]byte(c.ksy))
+		var fe *bitstring.FieldError
+		if !errors.As(err, &fe) || fe.Field != c.id || !errors.Is(err, c.want) {
+			t.Errorf("%s: want=%v in %s, out: %v", c.ksy, c.want, c.id, err)
+		}
+	}
+}
@@ -0,0 +1,497 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// A FieldKind represents the kind of value a field is decoded into.
+type FieldKind int
+
+const (
+	InvalidKind FieldKind = iota // field type cannot be decoded
+	UintKind                     // fixed size integer, signed if FieldLayout.Signed
+	BoolKind                     // bool
+	BytesKind                    // slice of bytes
+	StringKind                   // string
+	SliceKind                    // slice of structs
+	RecordKind                   // struct, as the root or an element of a Value tree
+)
+
+var kindNames = []string{
+	InvalidKind: "invalid",
+	UintKind:    "uint",
+	BoolKind:    "bool",
+	BytesKind:   "bytes",
+	StringKind:  "string",
+	SliceKind:   "slice",
+	RecordKind:  "record",
+}
+
+func (k FieldKind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "kind" + strconv.Itoa(int(k))
+}
+
+// MarshalText encodes k by its name.
+func (k FieldKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// A FieldLayout describes how a field is laid out in a bit array.
+type FieldLayout struct {
+	Name   string    // field name
+	Index  int       // index of the field in its struct
+	Kind   FieldKind // kind of the decoded value
+	Offset int       // bit offset from the start of the struct, or -1 if unknown
+	Bits   int       // bit width, or -1 if it depends on the decoded data
+	Static bool      // whether Bits is known without decoding
+	Signed bool      // whether a UintKind field is a two's complement integer
+
+	Mode    string            // string mode of a StringKind field
+	Charset string            // charset of a StringKind field
+	Count   string            // element count: a number or the name of a preceding field
+	Until   string            // "eof" if elements continue up to the end of the buffer
+	Enum    map[uint64]string // valid values of a UintKind field, or nil
+	Elem    *StructLayout     // layout of the elements of a SliceKind field
+	CRC     *CRCField         // checksum held by a UintKind field, or nil
+}
+
+// A StructLayout describes how a tagged struct is laid out in a bit array.
+// Fields without bits, binary, count or until tags are not part of it.
+type StructLayout struct {
+	Name   string       // name of the struct type or of the runtime layout
+	Type   reflect.Type // struct type, or nil for a runtime layout
+	Fields []FieldLayout
+	Bits   int  // total bit size, or -1 if it depends on the decoded data
+	Static bool // whether Bits is known without decoding
+
+	building  bool // set while the fields are being computed
+	checksums bool // set if a field holds a CRC
+}
+
+// A FieldError describes a struct field whose tags are invalid for its type.
+type FieldError struct {
+	Type  reflect.Type // type of the struct, or nil for a runtime layout
+	Field string       // name of the field
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	if e.Type == nil {
+		return "bitarray: field " + e.Field + ": " + e.Err.Error()
+	}
+	return "bitarray: field " + e.Field + " of " + e.Type.String() + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error { return e.Err }
+
+var (
+	ErrInvalidField   = errors.New("bitarray: field options do not match its kind")
+	ErrDuplicateField = errors.New("bitarray: field name is empty or used twice")
+)
+
+var layoutCache sync.Map // map[reflect.Type]*StructLayout
+
+// Layout returns the layout of the tagged struct type t without decoding any
+// data. The error is a *FieldError for the first field whose tags cannot be
+// decoded. The layout is a copy that the caller may modify.
+func Layout(t reflect.Type) (*StructLayout, error) {
+	l, err := layoutOf(t)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.validate(); err != nil {
+		return nil, err
+	}
+	return l.clone(make(map[*StructLayout]*StructLayout)), nil
+}
+
+// clone returns a deep copy of l. Layouts already copied are kept in
+// copies, so that recursive layouts refer to their copy.
+func (l *StructLayout) clone(copies map[*StructLayout]*StructLayout) *StructLayout {
+	if c, ok := copies[l]; ok {
+		return c
+	}
+	c := new(StructLayout)
+	*c = *l
+	copies[l] = c
+	c.Fields = append([]FieldLayout(nil), l.Fields...)
+	for i := range c.Fields {
+		f := &c.Fields[i]
+		if f.Enum != nil {
+			enum := make(map[uint64]string, len(f.Enum))
+			for k, v := range f.Enum {
+				enum[k] = v
+			}
+			f.Enum = enum
+		}
+		if f.CRC != nil {
+			crc := *f.CRC
+			f.CRC = &crc
+		}
+		if f.Elem != nil {
+			f.Elem = f.Elem.clone(copies)
+		}
+	}
+	return c
+}
+
+// validate reports fields of l and its element layouts that have a type
+// Unmarshal cannot decode.
+func (l *StructLayout) validate() error {
+	seen := map[*StructLayout]bool{}
+	var walk func(l *StructLayout) error
+	walk = func(l *StructLayout) error {
+		if seen[l] {
+			return nil
+		}
+		seen[l] = true
+		for _, f := range l.Fields {
+			if f.Kind == InvalidKind {
+				return &FieldError{Type: l.Type, Field: f.Name, Err: ErrUnsupportedFieldType}
+			}
+			if f.Elem != nil {
+				if err := walk(f.Elem); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(l)
+}
+
+// layoutOf returns the cached layout of t. Unlike Layout, it accepts fields
+// of unsupported types, which are left to the decoder.
+func layoutOf(t reflect.Type) (*StructLayout, error) {
+	if l, ok := layoutCache.Load(t); ok {
+		return l.(*StructLayout), nil
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, errors.New("bitarray: invalid type " + t.String())
+	}
+	built := make(map[reflect.Type]*StructLayout)
+	l, err := buildLayout(t, built)
+	if err != nil {
+		return nil, err
+	}
+	for t, l := range built {
+		layoutCache.Store(t, l)
+	}
+	return l, nil
+}
+
+// buildLayout computes the layout of t. Layouts under construction are kept
+// in built, so that recursive types refer to the same layout.
+func buildLayout(t reflect.Type, built map[reflect.Type]*StructLayout) (*StructLayout, error) {
+	if l, ok := built[t]; ok {
+		return l, nil
+	}
+	l := &StructLayout{Name: t.Name(), Type: t, Static: true, building: true}
+	built[t] = l
+	defer func() { l.building = false }()
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		f, ok, err := fieldLayout(sf, built)
+		if err != nil {
+			return nil, &FieldError{Type: t, Field: sf.Name, Err: err}
+		}
+		if !ok {
+			continue
+		}
+		f.Index = i
+		if !l.hasCount(f.Count) {
+			return nil, &FieldError{Type: t, Field: sf.Name, Err: ErrInvalidCountTag}
+		}
+		if tag := sf.Tag.Get("crc"); len(tag) != 0 {
+			if f.CRC, err = parseCRCTag(tag); err != nil {
+				return nil, &FieldError{Type: t, Field: sf.Name, Err: err}
+			}
+		}
+		if err := l.resolveCRC(&f); err != nil {
+			return nil, &FieldError{Type: t, Field: sf.Name, Err: err}
+		}
+		l.add(f)
+	}
+	if !l.Static {
+		l.Bits = -1
+	}
+	return l, nil
+}
+
+// add appends f to the fields of l, and updates the offset of f and the size
+// of l.
+func (l *StructLayout) add(f FieldLayout) {
+	if l.Static {
+		f.Offset = l.Bits
+	} else {
+		f.Offset = -1
+	}
+	if f.Static {
+		l.Bits += f.Bits
+	} else {
+		l.Static = false
+	}
+	if f.CRC != nil {
+		l.checksums = true
+	}
+	l.Fields = append(l.Fields, f)
+}
+
+// NewLayout returns a runtime layout named name, to decode data that has no
+// Go type with Decoder.DecodeMap. Each field needs a Name and a Kind, plus
+//
+//	UintKind, BoolKind: Bits
+//	BytesKind:          Bits (a multiple of 8), Count or Until
+//	StringKind:         Bits (a multiple of 8) and an optional Mode of
+//	                    CString, or a Mode of ZString or PString*; Charset
+//	SliceKind:          Count or Until, and Elem
+//
+// and optionally Signed, Enum and CRC for UintKind; the Enum values of a
+// Signed field are its two's complement bits. Index, Offset and Static are
+// computed, and so are the Params of CRC.
+func NewLayout(name string, fields []FieldLayout) (*StructLayout, error) {
+	l := &StructLayout{Name: name, Static: true}
+	names := make(map[string]bool)
+	for i, f := range fields {
+		if err := checkField(&f); err != nil {
+			return nil, &FieldError{Field: f.Name, Err: err}
+		}
+		if names[f.Name] || len(f.Name) == 0 {
+			return nil, &FieldError{Field: f.Name, Err: ErrDuplicateField}
+		}
+		if f.Name != "_" {
+			names[f.Name] = true
+		}
+		if !l.hasCount(f.Count) {
+			return nil, &FieldError{Field: f.Name, Err: ErrInvalidCountTag}
+		}
+		f.Index = i
+		if err := l.resolveCRC(&f); err != nil {
+			return nil, &FieldError{Field: f.Name, Err: err}
+		}
+		l.add(f)
+	}
+	if !l.Static {
+		l.Bits = -1
+	}
+	return l, nil
+}
+
+// checkField checks that the options of a field of a runtime layout match
+// its kind, and sets Bits and Static of dynamic fields.
+func checkField(f *FieldLayout) error {
+	f.Static = true
+	switch f.Kind {
+	case UintKind, BoolKind:
+		if f.Bits < 0 || f.Bits > int(Uint64Size) {
+			return ErrFieldSizeTooLarge
+		}
+		if len(f.Count) != 0 || len(f.Until) != 0 || len(f.Mode) != 0 || f.Elem != nil {
+			return ErrInvalidField
+		}
+		if f.Kind == BoolKind && (f.Enum != nil || f.Signed) {
+			return ErrInvalidField
+		}
+		return nil
+	case StringKind:
+		if len(f.Count) != 0 || len(f.Until) != 0 || f.Elem != nil {
+			return ErrInvalidField
+		}
+		switch f.Mode {
+		case "", CString:
+			if f.Bits < 0 || f.Bits%int(Uint8Size) != 0 {
+				return ErrInvalidField
+			}
+		case ZString, PString8, PString16, PString32:
+			f.Bits, f.Static = -1, false
+		default:
+			return ErrInvalidBinaryTag
+		}
+	case BytesKind, SliceKind:
+		if len(f.Mode) != 0 || len(f.Charset) != 0 || (f.Kind == SliceKind) != (f.Elem != nil) {
+			return ErrInvalidField
+		}
+		if len(f.Until) != 0 && f.Until != "eof" {
+			return ErrInvalidUntilTag
+		}
+		n, err := strconv.ParseUint(f.Count, 0, 64)
+		switch {
+		case len(f.Until) != 0 && len(f.Count) != 0:
+			return ErrInvalidField
+		case len(f.Until) != 0, len(f.Count) != 0 && err != nil:
+			f.Bits, f.Static = -1, false
+		case len(f.Count) != 0 && f.Kind == BytesKind:
+			f.Bits = int(n * Uint8Size)
+		case len(f.Count) != 0:
+			f.Bits, f.Static = int(n)*f.Elem.Bits, f.Elem.Static
+			if !f.Static {
+				f.Bits = -1
+			}
+		case f.Kind == SliceKind:
+			return ErrInvalidField
+		case f.Bits < 0 || f.Bits%int(Uint8Size) != 0:
+			return ErrInvalidField
+		}
+	default:
+		return ErrUnsupportedFieldType
+	}
+	if f.Enum != nil || f.Signed {
+		return ErrInvalidField
+	}
+	return nil
+}
+
+// widen returns the value of the bits v of f, as uint64(v) if f is signed.
+func (f *FieldLayout) widen(v uint64) uint64 {
+	if f.Signed {
+		return uint64(SignExtend(v, f.Bits))
+	}
+	return v
+}
+
+// hasCount reports whether tag is a valid count tag for the next field of l:
+// empty, a number, or the name of a uint field already in l.
+func (l *StructLayout) hasCount(tag string) bool {
+	if len(tag) == 0 {
+		return true
+	}
+	if _, err := strconv.ParseUint(tag, 0, 64); err == nil {
+		return true
+	}
+	for _, f := range l.Fields {
+		if f.Name == tag && f.Kind == UintKind && !f.Signed {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldLayout computes the layout of the struct field sf from its tags.
+// ok is false if sf is not tagged.
+func fieldLayout(sf reflect.StructField, built map[reflect.Type]*StructLayout) (f FieldLayout, ok bool, err error) {
+	f = FieldLayout{Name: sf.Name, Static: true}
+	bitsTag := sf.Tag.Get("bits")
+	binaryTag := sf.Tag.Get("binary")
+	countTag := sf.Tag.Get("count")
+	untilTag := sf.Tag.Get("until")
+
+	if sf.Type.Kind() == reflect.Slice && (len(countTag) != 0 || len(untilTag) != 0) {
+		switch sf.Type.Elem().Kind() {
+		case reflect.Uint8:
+			f.Kind = BytesKind
+		case reflect.Struct:
+			f.Kind = SliceKind
+			f.Elem, err = buildLayout(sf.Type.Elem(), built)
+			if err != nil {
+				return f, false, err
+			}
+		default:
+			return f, false, ErrUnsupportedFieldType
+		}
+		if len(untilTag) != 0 {
+			if untilTag != "eof" {
+				return f, false, ErrInvalidUntilTag
+			}
+			f.Until = untilTag
+			f.Bits, f.Static = -1, false
+			return f, true, nil
+		}
+		f.Count = countTag
+		n, err := strconv.ParseUint(countTag, 0, 64)
+		switch {
+		case err != nil:
+			f.Bits, f.Static = -1, false
+		case f.Kind == BytesKind:
+			f.Bits = int(n * Uint8Size)
+		case !f.Elem.building && f.Elem.Static:
+			f.Bits = int(n) * f.Elem.Bits
+		default:
+			f.Bits, f.Static = -1, false
+		}
+		return f, true, nil
+	}
+
+	var size uint64
+	switch {
+	case len(bitsTag) != 0:
+		size, err = strconv.ParseUint(bitsTag, 0, 64)
+		if err != nil {
+			return f, false, err
+		}
+	case len(binaryTag) != 0:
+		if sf.Type.Kind() != reflect.Slice && sf.Type.Kind() != reflect.String {
+			return f, false, ErrUnsupportedFieldType
+		}
+		size, f.Mode, err = parseBinaryTag(binaryTag)
+		if err != nil {
+			return f, false, err
+		}
+		if sf.Type.Kind() == reflect.Slice && len(f.Mode) != 0 {
+			return f, false, ErrUnsupportedFieldType
+		}
+	default:
+		return f, false, nil
+	}
+
+	switch sf.Type.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if size > uint64(sf.Type.Bits()) {
+			return f, false, ErrFieldSizeTooLarge
+		}
+		f.Kind = UintKind
+		f.Bits = int(size)
+		f.Signed = isSigned(sf.Type)
+		f.Enum, err = enumValues(sf, f.Bits)
+		if err != nil {
+			return f, false, err
+		}
+	case reflect.Bool:
+		if size > Uint64Size {
+			return f, false, ErrFieldSizeTooLarge
+		}
+		f.Kind = BoolKind
+		f.Bits = int(size)
+	case reflect.Slice:
+		// Both bits and binary tags give the size of a byte slice in bytes.
+		if sf.Type.Elem().Kind() != reflect.Uint8 {
+			return f, false, ErrUnsupportedFieldType
+		}
+		f.Kind = BytesKind
+		f.Bits = int(size * Uint8Size)
+	case reflect.String:
+		f.Kind = StringKind
+		f.Charset = sf.Tag.Get("charset")
+		if prefixSize(f.Mode) > 0 || f.Mode == ZString {
+			f.Bits, f.Static = -1, false
+		} else {
+			f.Bits = int(size * Uint8Size)
+		}
+	default:
+		f.Kind = InvalidKind
+		f.Bits = int(size)
+	}
+	return f, true, nil
+}
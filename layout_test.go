@@ -0,0 +1,173 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLayout(t *testing.T) {
+	type E struct {
+		F1 uint8 `bits:"3"`
+		F2 bool  `bits:"1"`
+	}
+	type S struct {
+		F1 uint8  `bits:"1"`
+		_  uint8  `bits:"7"`
+		F2 []byte `binary:"2"`
+		_  byte
+		F3 string `binary:"4,cstring"`
+		F4 []E    `count:"2"`
+		F5 string `binary:"pstring8"`
+		F6 uint16 `bits:"12"`
+		F7 []E    `until:"eof"`
+	}
+
+	l, err := Layout(reflect.TypeOf(S{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		name   string
+		index  int
+		kind   FieldKind
+		offset int
+		bits   int
+		static bool
+	}{
+		{"F1", 0, UintKind, 0, 1, true},
+		{"_", 1, UintKind, 1, 7, true},
+		{"F2", 2, BytesKind, 8, 16, true},
+		{"F3", 4, StringKind, 24, 32, true},
+		{"F4", 5, SliceKind, 56, 8, true},
+		{"F5", 6, StringKind, 64, -1, false},
+		{"F6", 7, UintKind, -1, 12, true},
+		{"F7", 8, SliceKind, -1, -1, false},
+	}
+	if len(l.Fields) != len(want) {
+		t.Fatalf("want %d fields, out: %d", len(want), len(l.Fields))
+	}
+	for i, w := range want {
+		f := l.Fields[i]
+		if f.Name != w.name || f.Index != w.index || f.Kind != w.kind ||
+			f.Offset != w.offset || f.Bits != w.bits || f.Static != w.static {
+			t.Errorf("%dth field: want=%v, out: %+v", i, w, f)
+		}
+	}
+	if l.Static || l.Bits != -1 {
+		t.Errorf("want dynamic size, out: %d, %v", l.Bits, l.Static)
+	}
+	if l.Fields[4].Elem.Bits != 4 || !l.Fields[4].Elem.Static {
+		t.Errorf("want 4 bits element, out: %+v", l.Fields[4].Elem)
+	}
+}
+
+func TestLayoutStatic(t *testing.T) {
+	type S struct {
+		F1 uint8  `bits:"4"`
+		F2 uint16 `bits:"12"`
+		F3 []byte `count:"3"`
+	}
+
+	l, err := Layout(reflect.TypeOf(S{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Static || l.Bits != 40 {
+		t.Errorf("want 40 bits, out: %d, %v", l.Bits, l.Static)
+	}
+}
+
+func TestLayoutRecursive(t *testing.T) {
+	type Node struct {
+		N        uint8  `bits:"8"`
+		Children []Node `count:"N"`
+	}
+
+	l, err := Layout(reflect.TypeOf(Node{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Fields[1].Elem != l {
+		t.Error("want element layout to refer to itself")
+	}
+	if l.Static {
+		t.Error("want dynamic size")
+	}
+}
+
+func TestLayoutError(t *testing.T) {
+	cases := []struct {
+		v   interface{}
+		err error
+	}{
+		{struct {
+			F1 uint8 `bits:"9"`
+		}{}, ErrFieldSizeTooLarge},
+		{struct {
+			F1 int `bits:"8"`
+		}{}, ErrUnsupportedFieldType},
+		{struct {
+			F1 uint8 `binary:"1"`
+		}{}, ErrUnsupportedFieldType},
+		{struct {
+			F1 []byte `count:"N"`
+			N  uint8  `bits:"8"`
+		}{}, ErrInvalidCountTag},
+		{struct {
+			F1 []byte `until:"end"`
+		}{}, ErrInvalidUntilTag},
+	}
+	for i, c := range cases {
+		_, err := Layout(reflect.TypeOf(c.v))
+		var fieldErr *FieldError
+		if !errors.As(err, &fieldErr) || fieldErr.Field != "F1" {
+			t.Errorf("%d: want *FieldError on F1, out: %v", i, err)
+		}
+		if !errors.Is(err, c.err) {
+			t.Errorf("%d: want=%v, out: %v", i, c.err, err)
+		}
+	}
+}
+
+func TestLayoutCopy(t *testing.T) {
+	type State uint8
+	type S struct {
+		F1 State  `bits:"4" enum:"1=Run"`
+		F2 uint16 `bits:"12"`
+	}
+
+	l, err := Layout(reflect.TypeOf(S{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Fields[0].Bits = 8
+	l.Fields[0].Enum[2] = "Fault"
+	l.Fields = l.Fields[:1]
+
+	out := &S{}
+	err = Unmarshal(NewBuffer(bytes.NewBuffer([]byte{0x2f, 0xff})), out)
+	if _, ok := err.(*EnumError); !ok {
+		t.Errorf("want *EnumError, out: %v", err)
+	}
+	if l, _ := Layout(reflect.TypeOf(S{})); len(l.Fields) != 2 || l.Fields[0].Bits != 4 || len(l.Fields[0].Enum) != 1 {
+		t.Errorf("want the cached layout untouched, out: %#v", l)
+	}
+}
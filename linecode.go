@@ -0,0 +1,321 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+	"strconv"
+)
+
+var (
+	ErrUnknownLineCode = errors.New("bitarray: unknown line code")
+	ErrPartialSymbol   = errors.New("bitarray: bits do not make a whole symbol")
+)
+
+// A LineCode is a way of sending data bits on a line.
+type LineCode int
+
+const (
+	Manchester       LineCode = iota // 0 as 10 and 1 as 01, after IEEE 802.3
+	ThomasManchester                 // 0 as 01 and 1 as 10, after G.E. Thomas
+	DiffManchester                   // 0 with and 1 without a transition before each mid-bit transition
+	NRZI                             // 1 as a transition and 0 as none
+	Code4B5B                         // each 4 bits as a 5 bit symbol
+	Code8B10B                        // each 8 bits as a 10 bit symbol of bounded disparity
+)
+
+var lineCodeNames = []string{
+	Manchester:       "Manchester",
+	ThomasManchester: "Thomas Manchester",
+	DiffManchester:   "differential Manchester",
+	NRZI:             "NRZI",
+	Code4B5B:         "4b/5b",
+	Code8B10B:        "8b/10b",
+}
+
+func (c LineCode) String() string {
+	if c < 0 || int(c) >= len(lineCodeNames) {
+		return "LineCode(" + strconv.Itoa(int(c)) + ")"
+	}
+	return lineCodeNames[c]
+}
+
+// A CodeError reports a symbol that is not valid in a line code, or not
+// with the running disparity of 8b/10b. Control symbols of 4b/5b and 8b/10b
+// are reported too, since they have no data bits.
+type CodeError struct {
+	Code   LineCode // line code of the bits
+	Offset uint64   // bit offset of the symbol in the coded bits
+	Symbol uint64   // bits of the symbol
+}
+
+func (e *CodeError) Error() string {
+	return "bitarray: invalid " + e.Code.String() + " symbol at bit " + strconv.FormatUint(e.Offset, 10)
+}
+
+// code5 are the 4b/5b symbols of the 16 data nibbles.
+var code5 = [16]uint8{
+	0b11110, 0b01001, 0b10100, 0b10101, 0b01010, 0b01011, 0b01110, 0b01111,
+	0b10010, 0b10011, 0b10110, 0b10111, 0b11010, 0b11011, 0b11100, 0b11101,
+}
+
+// code6 and code4 are the 8b/10b sub-block symbols of the low 5 bits and
+// the high 3 bits of a byte, at a running disparity of -1.
+var (
+	code6 = [32]uint8{
+		0b100111, 0b011101, 0b101101, 0b110001, 0b110101, 0b101001, 0b011001, 0b111000,
+		0b111001, 0b100101, 0b010101, 0b110100, 0b001101, 0b101100, 0b011100, 0b010111,
+		0b011011, 0b100011, 0b010011, 0b110010, 0b001011, 0b101010, 0b011010, 0b111010,
+		0b110011, 0b100110, 0b010110, 0b110110, 0b001110, 0b101110, 0b011110, 0b101011,
+	}
+	code4 = [8]uint8{0b1011, 0b1001, 0b0101, 0b1100, 0b1101, 0b1010, 0b0110, 0b1110}
+)
+
+// sym6 returns the 6 bit symbol of x at running disparity rd.
+func sym6(x int, rd int) uint8 {
+	c := code6[x]
+	if rd > 0 && (bits.OnesCount8(c) != 3 || x == 7) {
+		c ^= 0x3f
+	}
+	return c
+}
+
+// sym4 returns the 4 bit symbol of y following that of x at running
+// disparity rd. The alternate symbol of 7 avoids runs of five equal bits.
+func sym4(y, x int, rd int) uint8 {
+	c := code4[y]
+	if y == 7 && (rd < 0 && (x == 17 || x == 18 || x == 20) || rd > 0 && (x == 11 || x == 13 || x == 14)) {
+		c = 0b0111
+	}
+	if rd > 0 && (bits.OnesCount8(c) != 2 || y == 3) {
+		c ^= 0xf
+	}
+	return c
+}
+
+// disparity returns the running disparity after the k bit symbol c.
+func disparity(rd int, c uint64, k int) int {
+	if bits.OnesCount64(c)*2 != k {
+		return -rd
+	}
+	return rd
+}
+
+// A lineDecoder pops the data bits of line coded bits.
+type lineDecoder struct {
+	code  LineCode
+	b     *Buffer
+	level uint8 // line level at the end of the last symbol.
+	rd    int   // running disparity of 8b/10b.
+}
+
+// NewLineDecoder returns a Buffer that pops the data bits of the bits of b
+// sent in code, so that they can be popped or decoded as usual. A pop that
+// meets an invalid symbol returns a *CodeError without popping any bits,
+// and the next pop goes on past the symbol. It returns io.ErrUnexpectedEOF
+// if b ends within a symbol. Differential Manchester and NRZI take the line
+// to be low before b, and 8b/10b the running disparity to be -1. The symbols of 4b/5b and 8b/10b are each taken from their first
+// bit sent, and decode to 4 bits and a byte, most significant bit first.
+// Line codes can be stacked, as in
+//
+//	NewLineDecoder(NewLineDecoder(b, NRZI), Code4B5B)
+func NewLineDecoder(b *Buffer, code LineCode) *Buffer {
+	d := &lineDecoder{code: code, b: b, rd: -1}
+	return newBitReader(d.next)
+}
+
+// symbol pops the next k bits of b.
+func (d *lineDecoder) symbol(k uint64) (uint64, error) {
+	if eof, err := d.b.AtEOF(); eof || err != nil {
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, err
+	}
+	s, err := d.b.PopUint16(k)
+	if d.b.Truncated() {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	return uint64(s), nil
+}
+
+func (d *lineDecoder) next() (uint64, uint64, error) {
+	off := d.b.Pos()
+	switch d.code {
+	case Manchester, ThomasManchester, DiffManchester:
+		s, err := d.symbol(2)
+		if err != nil {
+			return 0, 0, err
+		}
+		if s == 0b00 || s == 0b11 {
+			d.level = uint8(s & 1)
+			return 0, 0, &CodeError{d.code, off, s}
+		}
+		switch d.code {
+		case Manchester:
+			return s & 1, 1, nil
+		case ThomasManchester:
+			return s >> 1, 1, nil
+		}
+		v := uint64(0)
+		if uint8(s>>1) == d.level {
+			v = 1
+		}
+		d.level = uint8(s & 1)
+		return v, 1, nil
+	case NRZI:
+		s, err := d.symbol(1)
+		if err != nil {
+			return 0, 0, err
+		}
+		v := s ^ uint64(d.level)
+		d.level = uint8(s)
+		return v, 1, nil
+	case Code4B5B:
+		s, err := d.symbol(5)
+		if err != nil {
+			return 0, 0, err
+		}
+		for v, c := range code5 {
+			if uint64(c) == s {
+				return uint64(v), 4, nil
+			}
+		}
+		return 0, 0, &CodeError{d.code, off, s}
+	case Code8B10B:
+		s, err := d.symbol(10)
+		if err != nil {
+			return 0, 0, err
+		}
+		rd := d.rd
+		// Take up the disparity of the symbol, even if it is invalid.
+		switch n := bits.OnesCount64(s); {
+		case n > 5:
+			d.rd = 1
+		case n < 5:
+			d.rd = -1
+		}
+		x := -1
+		for i := range code6 {
+			if uint64(sym6(i, rd)) == s>>4 {
+				x = i
+			}
+		}
+		if x < 0 {
+			return 0, 0, &CodeError{d.code, off, s}
+		}
+		rd = disparity(rd, s>>4, 6)
+		for y := range code4 {
+			if uint64(sym4(y, x, rd)) == s&0xf {
+				d.rd = disparity(rd, s&0xf, 4)
+				return uint64(y<<5 | x), 8, nil
+			}
+		}
+		return 0, 0, &CodeError{d.code, off, s}
+	}
+	return 0, 0, ErrUnknownLineCode
+}
+
+// A lineEncoder is a bitWriter that pushes bits to a Writer in a line
+// code.
+type lineEncoder struct {
+	code  LineCode
+	w     *Writer
+	level uint8  // line level at the end of the last symbol.
+	rd    int    // running disparity of 8b/10b.
+	pend  uint64 // data bits of a partial symbol.
+	pn    uint64 // number of bits in pend.
+}
+
+// NewLineEncoder returns a Writer that pushes the bits written to it to w,
+// sent in code. It is the inverse of NewLineDecoder. Its Flush passes
+// pending bits on to w without padding them, and returns ErrPartialSymbol
+// if they do not make a whole symbol; flush w itself to write them out.
+func NewLineEncoder(w *Writer, code LineCode) *Writer {
+	return NewWriter(&lineEncoder{code: code, w: w, rd: -1})
+}
+
+func (e *lineEncoder) WriteByte(c byte) error {
+	return e.push(c, Uint8Size)
+}
+
+func (e *lineEncoder) flush(v uint8, size uint64) error {
+	if err := e.push(v, size); err != nil {
+		return err
+	}
+	if e.pn != 0 {
+		e.pend, e.pn = 0, 0
+		return ErrPartialSymbol
+	}
+	return nil
+}
+
+// push pushes the lowest size bits of v.
+func (e *lineEncoder) push(v uint8, size uint64) error {
+	for i := size; i > 0; i-- {
+		e.pend = e.pend<<1 | uint64(v>>(i-1)&1)
+		e.pn++
+		if err := e.symbol(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// symbol pushes the symbol of the bits in pend, once they make one.
+func (e *lineEncoder) symbol() error {
+	c := uint8(e.pend)
+	switch e.code {
+	case Manchester:
+		e.pend, e.pn = 0, 0
+		return e.w.PushUint8(0b10>>c, 2)
+	case ThomasManchester:
+		e.pend, e.pn = 0, 0
+		return e.w.PushUint8(0b01<<c, 2)
+	case DiffManchester:
+		e.pend, e.pn = 0, 0
+		first := e.level ^ 1 ^ c
+		e.level = first ^ 1
+		return e.w.PushUint8(first<<1|e.level, 2)
+	case NRZI:
+		e.pend, e.pn = 0, 0
+		e.level ^= c
+		return e.w.PushUint8(e.level, 1)
+	case Code4B5B:
+		if e.pn < 4 {
+			return nil
+		}
+		e.pend, e.pn = 0, 0
+		return e.w.PushUint8(code5[c], 5)
+	case Code8B10B:
+		if e.pn < 8 {
+			return nil
+		}
+		e.pend, e.pn = 0, 0
+		x, y := int(c&0x1f), int(c>>5)
+		s6 := sym6(x, e.rd)
+		e.rd = disparity(e.rd, uint64(s6), 6)
+		s4 := sym4(y, x, e.rd)
+		e.rd = disparity(e.rd, uint64(s4), 4)
+		return e.w.PushUint16(uint16(s6)<<4|uint16(s4), 10)
+	}
+	return ErrUnknownLineCode
+}
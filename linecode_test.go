@@ -0,0 +1,169 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+var lineCases = []struct {
+	code  LineCode
+	data  string
+	coded string
+}{
+	{Manchester, "1010", "01 10 01 10"},
+	{ThomasManchester, "1010", "10 01 10 01"},
+	{DiffManchester, "1100", "01 10 10 10"},
+	{NRZI, "1101", "1001"},
+	{Code4B5B, "0x0f", "11110 11101"},
+	{Code8B10B, "0x00, 0x00", "100111 0100 100111 0100"},
+	{Code8B10B, "0xb5", "101010 1010"},
+	{Code8B10B, "0x07, 0xf1", "111000 1011 100011 0001"},
+	{Code8B10B, "0xf1, 0xf1", "100011 0111 100011 0001"},
+}
+
+// lineEncode returns the bits of s sent in code.
+func lineEncode(t *testing.T, s BitString, code LineCode) BitString {
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	lw := NewLineEncoder(w, code)
+	for i := 0; i < s.Len(); i++ {
+		if err := lw.PushUint8(uint8(s.bits(i, 1)), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	n := w.pos
+	w.Flush()
+	coded, _ := NewBitString(out.Bytes(), int(n))
+	return coded
+}
+
+// lineDecode returns the data bits of s sent in code.
+func lineDecode(s BitString, code LineCode) (BitString, error) {
+	b := NewLineDecoder(s.Reader(), code)
+	var bld builder
+	for {
+		if eof, err := b.AtEOF(); eof || err != nil {
+			return bld.bitString(), err
+		}
+		v, err := b.PopUint8(1)
+		if err != nil && err != io.EOF {
+			return bld.bitString(), err
+		}
+		bld.push(uint64(v), 1)
+	}
+}
+
+func TestLineCode(t *testing.T) {
+	for _, c := range lineCases {
+		data, coded := MustParseBitString(c.data), MustParseBitString(c.coded)
+		if out := lineEncode(t, data, c.code); !out.Equal(coded) {
+			t.Errorf("%v %s: want=%v, out: %v", c.code, c.data, coded, out)
+		}
+		if out, err := lineDecode(coded, c.code); err != nil || !out.Equal(data) {
+			t.Errorf("%v %s: want=%v, out: %v, %v", c.code, c.coded, data, out, err)
+		}
+	}
+}
+
+func TestLineCodeRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for code := Manchester; code <= Code8B10B; code++ {
+		for i := 0; i < 50; i++ {
+			data, _ := randomBits(r, 8*r.Intn(20))
+			out, err := lineDecode(lineEncode(t, data, code), code)
+			if err != nil || !out.Equal(data) {
+				t.Errorf("%v: want=%v, out: %v, %v", code, data, out, err)
+			}
+		}
+	}
+}
+
+func TestLineCodeError(t *testing.T) {
+	cases := []struct {
+		code   LineCode
+		coded  string
+		offset uint64
+	}{
+		{Manchester, "01 11 10", 2},
+		{DiffManchester, "01 10 00", 4},
+		{Code4B5B, "11110 00000", 5},
+		{Code8B10B, "001111 1010", 0},              // K.28.5
+		{Code8B10B, "100111 0100 011000 1011", 10}, // D.0.0 at the wrong disparity
+		{Code8B10B, "110001 1011 101110 1000", 10}, // K.29.7
+	}
+	for _, c := range cases {
+		_, err := lineDecode(MustParseBitString(c.coded), c.code)
+		var codeErr *CodeError
+		if !errors.As(err, &codeErr) || codeErr.Code != c.code || codeErr.Offset != c.offset {
+			t.Errorf("%v %s: want *CodeError at %d, out: %v", c.code, c.coded, c.offset, err)
+		}
+	}
+
+	if _, err := lineDecode(MustParseBitString("100111 010"), Code8B10B); err != io.ErrUnexpectedEOF {
+		t.Errorf("want=%v, out: %v", io.ErrUnexpectedEOF, err)
+	}
+	w := NewLineEncoder(NewWriter(&bytes.Buffer{}), Code4B5B)
+	w.PushUint8(0x5, 3)
+	if err := w.Flush(); err != ErrPartialSymbol {
+		t.Errorf("want=%v, out: %v", ErrPartialSymbol, err)
+	}
+}
+
+func TestLineCodeErrorResume(t *testing.T) {
+	// 0xa5, 0x3c, 0xff in 4b/5b with the symbol of 5 replaced.
+	coded := MustParseBitString("10110 00000 10101 11010 11101 11101")
+	data := MustParseBitString("0xa, 0x3, 0xc, 0xf, 0xf")
+	for _, size := range []uint64{1, 2, 4, 5, 10} {
+		b := NewLineDecoder(coded.Reader(), Code4B5B)
+		var bld builder
+		errs := 0
+		for {
+			v, err := b.PopUint16(size)
+			if err == io.EOF && b.Truncated() {
+				break
+			}
+			var codeErr *CodeError
+			if errors.As(err, &codeErr) {
+				if codeErr.Offset != 5 {
+					t.Errorf("size %d: want *CodeError at 5, out: %v", size, codeErr.Offset)
+				}
+				if errs++; errs > 1 {
+					break
+				}
+				continue
+			}
+			if err != nil && err != io.EOF {
+				t.Errorf("size %d: %v", size, err)
+				break
+			}
+			bld.push(uint64(v), int(size))
+			if err == io.EOF {
+				break
+			}
+		}
+		if out := bld.bitString(); errs != 1 || !out.Equal(data) {
+			t.Errorf("size %d: want=%v, out: %v, %d errors", size, data, out, errs)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"math/bits"
+)
+
+// zeros returns a bit string of n zero bits.
+func zeros(n int) BitString {
+	return BitString{words: make([]uint64, (n+63)/64), n: n}
+}
+
+// mask clears the bits of the last word past n.
+func (s BitString) mask() BitString {
+	if r := s.n % 64; r != 0 {
+		s.words[len(s.words)-1] &^= ^uint64(0) >> uint(r)
+	}
+	return s
+}
+
+// zip combines the words of s and o with op. It panics if their lengths
+// differ.
+func (s BitString) zip(o BitString, op func(a, b uint64) uint64) BitString {
+	if s.n != o.n {
+		panic("bitarray: BitString lengths differ")
+	}
+	z := zeros(s.n)
+	for i := range z.words {
+		z.words[i] = op(s.words[i], o.words[i])
+	}
+	return z
+}
+
+// And returns the bitwise AND of s and o, which must have the same length.
+func (s BitString) And(o BitString) BitString {
+	return s.zip(o, func(a, b uint64) uint64 { return a & b })
+}
+
+// Or returns the bitwise OR of s and o, which must have the same length.
+func (s BitString) Or(o BitString) BitString {
+	return s.zip(o, func(a, b uint64) uint64 { return a | b })
+}
+
+// Xor returns the bitwise XOR of s and o, which must have the same length.
+func (s BitString) Xor(o BitString) BitString {
+	return s.zip(o, func(a, b uint64) uint64 { return a ^ b })
+}
+
+// Not returns s with every bit inverted.
+func (s BitString) Not() BitString {
+	z := zeros(s.n)
+	for i := range z.words {
+		z.words[i] = ^s.words[i]
+	}
+	return z.mask()
+}
+
+// ShiftLeft returns s shifted k bits towards its start, filling in zero
+// bits at its end. The length is unchanged. It panics if k is negative.
+func (s BitString) ShiftLeft(k int) BitString {
+	if k < 0 {
+		panic("bitarray: negative shift count")
+	}
+	if k >= s.n {
+		return zeros(s.n)
+	}
+	return s.Slice(k, s.n).Concat(zeros(k))
+}
+
+// ShiftRight returns s shifted k bits towards its end, filling in zero
+// bits at its start. The length is unchanged. It panics if k is negative.
+func (s BitString) ShiftRight(k int) BitString {
+	if k < 0 {
+		panic("bitarray: negative shift count")
+	}
+	if k >= s.n {
+		return zeros(s.n)
+	}
+	return zeros(k).Concat(s.Slice(0, s.n-k))
+}
+
+// RotateLeft returns s rotated k bits towards its start, with the bits
+// shifted out reentering at its end. To rotate towards the end, call it
+// with a negative k.
+func (s BitString) RotateLeft(k int) BitString {
+	if s.n == 0 {
+		return s
+	}
+	k %= s.n
+	if k < 0 {
+		k += s.n
+	}
+	return s.Slice(k, s.n).Concat(s.Slice(0, k))
+}
+
+// OnesCount returns the number of one bits in s.
+func (s BitString) OnesCount() int {
+	n := 0
+	for _, w := range s.words {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// LeadingZeros returns the number of zero bits before the first one bit of
+// s; it is Len for a bit string of zeros.
+func (s BitString) LeadingZeros() int {
+	if i := s.FirstSet(); i >= 0 {
+		return i
+	}
+	return s.n
+}
+
+// TrailingZeros returns the number of zero bits after the last one bit of
+// s; it is Len for a bit string of zeros.
+func (s BitString) TrailingZeros() int {
+	for i := len(s.words) - 1; i >= 0; i-- {
+		if s.words[i] != 0 {
+			last := i*64 + 63 - bits.TrailingZeros64(s.words[i])
+			return s.n - 1 - last
+		}
+	}
+	return s.n
+}
+
+// FirstSet returns the index of the first one bit of s, or -1 if there is
+// none.
+func (s BitString) FirstSet() int {
+	return s.NextSet(0)
+}
+
+// NextSet returns the index of the first one bit of s at or after i, or -1
+// if there is none.
+func (s BitString) NextSet(i int) int {
+	if i < 0 {
+		i = 0
+	}
+	if i >= s.n {
+		return -1
+	}
+	w := i / 64
+	word := s.words[w] & (^uint64(0) >> uint(i%64))
+	for {
+		if word != 0 {
+			return w*64 + bits.LeadingZeros64(word)
+		}
+		w++
+		if w == len(s.words) {
+			return -1
+		}
+		word = s.words[w]
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestBitStringLogic(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		n := r.Intn(300)
+		a, abits := randomBits(r, n)
+		b, bbits := randomBits(r, n)
+		and, or, xor, not := make([]bool, n), make([]bool, n), make([]bool, n), make([]bool, n)
+		for j := 0; j < n; j++ {
+			and[j] = abits[j] && bbits[j]
+			or[j] = abits[j] || bbits[j]
+			xor[j] = abits[j] != bbits[j]
+			not[j] = !abits[j]
+		}
+		checkBits(t, a.And(b), and)
+		checkBits(t, a.Or(b), or)
+		checkBits(t, a.Xor(b), xor)
+		checkBits(t, a.Not(), not)
+		if !a.Not().Not().Equal(a) {
+			t.Errorf("want=%v, out: %v", a, a.Not().Not())
+		}
+
+		k := r.Intn(n + 10)
+		shl, shr, rotl := make([]bool, n), make([]bool, n), make([]bool, n)
+		for j := 0; j < n; j++ {
+			if j+k < n {
+				shl[j] = abits[j+k]
+			}
+			if j-k >= 0 {
+				shr[j] = abits[j-k]
+			}
+			rotl[j] = abits[(j+k)%n]
+		}
+		checkBits(t, a.ShiftLeft(k), shl)
+		checkBits(t, a.ShiftRight(k), shr)
+		checkBits(t, a.RotateLeft(k), rotl)
+		if !a.RotateLeft(k).RotateLeft(-k).Equal(a) {
+			t.Errorf("want=%v, out: %v", a, a.RotateLeft(k).RotateLeft(-k))
+		}
+
+		ones, first, last := 0, -1, -1
+		for j, bit := range abits {
+			if bit {
+				ones++
+				if first < 0 {
+					first = j
+				}
+				last = j
+			}
+		}
+		leading, trailing := n, n
+		if first >= 0 {
+			leading, trailing = first, n-1-last
+		}
+		if a.OnesCount() != ones || a.FirstSet() != first ||
+			a.LeadingZeros() != leading || a.TrailingZeros() != trailing {
+			t.Errorf("%v: want=%d ones, first %d, %d leading, %d trailing, out: %d, %d, %d, %d",
+				a, ones, first, leading, trailing, a.OnesCount(), a.FirstSet(), a.LeadingZeros(), a.TrailingZeros())
+		}
+		from, next := r.Intn(n+1), -1
+		for j := from; j < n; j++ {
+			if abits[j] {
+				next = j
+				break
+			}
+		}
+		if a.NextSet(from) != next {
+			t.Errorf("%v: NextSet(%d) want=%d, out: %d", a, from, next, a.NextSet(from))
+		}
+	}
+}
+
+func TestBitStringLogicPanics(t *testing.T) {
+	a, _ := ParseBitString("0b101")
+	b, _ := ParseBitString("0b1010")
+	for _, f := range []func(){
+		func() { a.And(b) },
+		func() { a.ShiftLeft(-1) },
+		func() { a.ShiftRight(-1) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("want=panic, out: none")
+				}
+			}()
+			f()
+		}()
+	}
+	if s := (BitString{}).RotateLeft(3); s.Len() != 0 {
+		t.Errorf("want=empty, out: %v", s)
+	}
+}
@@ -0,0 +1,587 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrInvalidPattern = errors.New("bitarray: invalid bit syntax pattern")
+	ErrNoMatch        = errors.New("bitarray: data does not match pattern")
+	ErrArgCount       = errors.New("bitarray: number of arguments does not match pattern")
+	ErrArgType        = errors.New("bitarray: argument does not fit pattern segment")
+)
+
+// segType is the type of a segment of a bit syntax pattern.
+type segType int
+
+const (
+	segInteger segType = iota
+	segFloat
+	segBinary
+	segBits
+)
+
+// A segment is one element of a bit syntax pattern.
+type segment struct {
+	name    string // variable name, "_", or empty for a literal
+	literal uint64 // value of a literal segment
+	size    int    // size in units, or -1 for the rest of the data
+	sizeVar string // name of an earlier segment holding the size
+	unit    int
+	typ     segType
+	signed  bool
+	little  bool
+}
+
+// bound reports whether the segment binds an argument.
+func (s *segment) bound() bool {
+	return len(s.name) != 0 && s.name != "_"
+}
+
+// maxPatterns bounds the number of parsed patterns kept in patternCache,
+// which is emptied when it is full, so that patterns built at run time do
+// not make it grow without end.
+const maxPatterns = 256
+
+var patternCache struct {
+	sync.Mutex
+	m map[string][]segment
+}
+
+// parsePattern parses a bit syntax pattern in the notation of Erlang, such
+// as "<<Ver:4, IHL:4, _:8, Len:16, Data:Len/binary, Rest/bits>>".
+func parsePattern(pattern string) ([]segment, error) {
+	patternCache.Lock()
+	segs, ok := patternCache.m[pattern]
+	patternCache.Unlock()
+	if ok {
+		return segs, nil
+	}
+	segs, err := parseSegments(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patternCache.Lock()
+	if len(patternCache.m) >= maxPatterns || patternCache.m == nil {
+		patternCache.m = make(map[string][]segment)
+	}
+	patternCache.m[pattern] = segs
+	patternCache.Unlock()
+	return segs, nil
+}
+
+// parseSegments parses the segments of pattern.
+func parseSegments(pattern string) ([]segment, error) {
+	p := strings.TrimSpace(pattern)
+	if strings.HasPrefix(p, "<<") && strings.HasSuffix(p, ">>") {
+		p = strings.TrimSpace(p[2 : len(p)-2])
+	}
+	var segs []segment
+	names := make(map[string]bool)
+	if len(p) != 0 {
+		for _, s := range strings.Split(p, ",") {
+			seg, err := parseSegment(strings.TrimSpace(s), names)
+			if err != nil {
+				return nil, err
+			}
+			if len(segs) > 0 && segs[len(segs)-1].size < 0 {
+				return nil, ErrInvalidPattern // only the last segment may take the rest
+			}
+			segs = append(segs, seg)
+			if seg.bound() && seg.typ == segInteger {
+				names[seg.name] = true
+			}
+		}
+	}
+	return segs, nil
+}
+
+// parseSegment parses Value[:Size][/Type-Specifiers].
+func parseSegment(s string, names map[string]bool) (segment, error) {
+	seg := segment{unit: -1}
+	head, specs := s, ""
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		head, specs = s[:i], s[i+1:]
+	}
+	value, size := head, ""
+	if i := strings.IndexByte(head, ':'); i >= 0 {
+		value, size = head[:i], head[i+1:]
+	}
+	value, size = strings.TrimSpace(value), strings.TrimSpace(size)
+
+	switch {
+	case len(value) == 0:
+		return seg, ErrInvalidPattern
+	case isDigit(value[0]):
+		v, err := strconv.ParseUint(value, 0, 64)
+		if err != nil {
+			return seg, ErrInvalidPattern
+		}
+		seg.literal = v
+	case isName(value):
+		seg.name = value
+	default:
+		return seg, ErrInvalidPattern
+	}
+
+	if len(specs) != 0 {
+		for _, spec := range strings.Split(specs, "-") {
+			switch spec = strings.TrimSpace(spec); spec {
+			case "integer":
+				seg.typ = segInteger
+			case "float":
+				seg.typ = segFloat
+			case "binary", "bytes":
+				seg.typ = segBinary
+			case "bits", "bitstring":
+				seg.typ = segBits
+			case "signed", "unsigned":
+				seg.signed = spec == "signed"
+			case "big", "little":
+				seg.little = spec == "little"
+			default:
+				if !strings.HasPrefix(spec, "unit:") {
+					return seg, ErrInvalidPattern
+				}
+				unit, err := strconv.Atoi(spec[len("unit:"):])
+				if err != nil || unit < 1 || unit > 256 {
+					return seg, ErrInvalidPattern
+				}
+				seg.unit = unit
+			}
+		}
+	}
+	if seg.unit < 0 {
+		seg.unit = 1
+		if seg.typ == segBinary {
+			seg.unit = 8
+		}
+	}
+
+	switch {
+	case len(size) == 0:
+		switch seg.typ {
+		case segInteger:
+			seg.size = 8
+		case segFloat:
+			seg.size = 64
+		default:
+			seg.size = -1
+		}
+	case isDigit(size[0]):
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 0 {
+			return seg, ErrInvalidPattern
+		}
+		seg.size = n
+	case names[size]:
+		seg.sizeVar = size
+	default:
+		return seg, ErrInvalidPattern
+	}
+
+	if seg.size >= 0 {
+		bits := seg.size * seg.unit
+		switch seg.typ {
+		case segInteger:
+			if bits > 64 || seg.little && bits%8 != 0 || !seg.fits(bits) {
+				return seg, ErrInvalidPattern
+			}
+		case segFloat:
+			if bits != 32 && bits != 64 {
+				return seg, ErrInvalidPattern
+			}
+		}
+	}
+	if seg.typ != segInteger && !seg.bound() && seg.name != "_" {
+		return seg, ErrInvalidPattern // literals are integers
+	}
+	if seg.size < 0 && seg.typ == segInteger {
+		return seg, ErrInvalidPattern
+	}
+	return seg, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && isDigit(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// fits reports whether the literal of s, if it is one, fits in bits bits.
+func (s *segment) fits(bits int) bool {
+	return len(s.name) != 0 || bits >= 64 || s.literal>>uint(bits) == 0
+}
+
+// bits returns the size of seg in bits, given the values of earlier integer
+// segments, or -1 if it takes the rest of the data.
+func (s *segment) bits(values map[string]uint64) (int, error) {
+	size := s.size
+	if len(s.sizeVar) != 0 {
+		v := values[s.sizeVar]
+		if v > math.MaxInt32 {
+			return 0, ErrNoMatch
+		}
+		size = int(v)
+	}
+	if size < 0 {
+		return -1, nil
+	}
+	bits := size * s.unit
+	if s.typ == segInteger && (bits > 64 || s.little && bits%8 != 0 || !s.fits(bits)) {
+		return 0, ErrInvalidPattern
+	}
+	if s.typ == segFloat && bits != 32 && bits != 64 {
+		return 0, ErrInvalidPattern
+	}
+	return bits, nil
+}
+
+// Match matches data against a bit syntax pattern in the notation of
+// Erlang and stores the values of its variables in dst, in order. Segments
+// are Value:Size/Specifiers, where Value is a variable, _ to skip the
+// segment, or an integer literal the data must hold, which must fit in the
+// segment; Size is a number or an earlier variable; and Specifiers are
+// joined by - from the type (integer, float, binary, bits), signedness
+// (signed, unsigned), endianness (big, little) and unit:N. For example,
+//
+//	var ver, ihl uint8
+//	var length uint16
+//	var rest []byte
+//	err := Match("<<Ver:4, IHL:4, _:8, Len:16, Rest/binary>>", data, &ver, &ihl, &length, &rest)
+//
+// Integers go to pointers to integer types, floats to *float32 or *float64,
+// and binaries and bits to *[]byte or *BitString. A binary or bits segment
+// without size takes the rest of the data. The pattern must match all of
+// data, or ErrNoMatch is returned.
+func Match(pattern string, data []byte, dst ...interface{}) error {
+	segs, err := parsePattern(pattern)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for i := range segs {
+		if segs[i].bound() {
+			n++
+		}
+	}
+	if n != len(dst) {
+		return ErrArgCount
+	}
+
+	b := NewBuffer(bytes.NewReader(data))
+	total := uint64(len(data)) * Uint8Size
+	values := make(map[string]uint64)
+	for i := range segs {
+		seg := &segs[i]
+		size, err := seg.bits(values)
+		if err != nil {
+			return err
+		}
+		left := total - b.Pos()
+		if size < 0 {
+			if left%uint64(seg.unit) != 0 {
+				return ErrNoMatch
+			}
+			size = int(left)
+		}
+		if uint64(size) > left {
+			return ErrNoMatch
+		}
+
+		var v interface{}
+		switch seg.typ {
+		case segInteger:
+			u, err := popBits(b, size)
+			if err != nil {
+				return err
+			}
+			if seg.little {
+				u = swapBytes(u, size)
+			}
+			if !seg.bound() && seg.name != "_" && u != seg.literal {
+				return ErrNoMatch
+			}
+			values[seg.name] = u
+			if seg.signed && size > 0 && size < 64 && u>>uint(size-1) != 0 {
+				v = int64(u | ^uint64(0)<<uint(size))
+			} else if seg.signed {
+				v = int64(u)
+			} else {
+				v = u
+			}
+		case segFloat:
+			u, err := popBits(b, size)
+			if err != nil {
+				return err
+			}
+			if seg.little {
+				u = swapBytes(u, size)
+			}
+			if size == 32 {
+				v = float64(math.Float32frombits(uint32(u)))
+			} else {
+				v = math.Float64frombits(u)
+			}
+		default:
+			var bld builder
+			for k := size; k > 0; k -= 64 {
+				w := k
+				if w > 64 {
+					w = 64
+				}
+				u, err := popBits(b, w)
+				if err != nil {
+					return err
+				}
+				bld.push(u, w)
+			}
+			v = bld.bitString()
+		}
+		if seg.bound() {
+			if err := bind(dst[0], v); err != nil {
+				return err
+			}
+			dst = dst[1:]
+		}
+	}
+	if b.Pos() != total {
+		return ErrNoMatch
+	}
+	return nil
+}
+
+// popBits pops size bits, which are known to be in b.
+func popBits(b *Buffer, size int) (uint64, error) {
+	v, err := b.PopUint64(uint64(size))
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	return v, nil
+}
+
+// swapBytes reverses the order of the size/8 bytes of v.
+func swapBytes(v uint64, size int) uint64 {
+	var out uint64
+	for i := 0; i < size/8; i++ {
+		out = out<<8 | v&0xff
+		v >>= 8
+	}
+	return out
+}
+
+// bind stores v, a uint64, int64, float64 or BitString, in dst.
+func bind(dst interface{}, v interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrArgType
+	}
+	rv = rv.Elem()
+	switch v := v.(type) {
+	case uint64:
+		switch rv.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if rv.OverflowUint(v) {
+				return ErrArgType
+			}
+			rv.SetUint(v)
+			return nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v > math.MaxInt64 || rv.OverflowInt(int64(v)) {
+				return ErrArgType
+			}
+			rv.SetInt(int64(v))
+			return nil
+		}
+	case int64:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if rv.OverflowInt(v) {
+				return ErrArgType
+			}
+			rv.SetInt(v)
+			return nil
+		}
+	case float64:
+		switch rv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			rv.SetFloat(v)
+			return nil
+		}
+	case BitString:
+		switch d := dst.(type) {
+		case *BitString:
+			*d = v
+			return nil
+		case *[]byte:
+			if v.Len()%8 != 0 {
+				return ErrArgType
+			}
+			*d = v.Bytes()
+			return nil
+		}
+	}
+	return ErrArgType
+}
+
+// Build is the inverse of Match: it returns the bit string made of the
+// segments of pattern, taking the values of variables from args in order.
+// Segments named _ are zero bits. Integers are taken from any integer type,
+// floats from float32 or float64, and binaries and bits from []byte, string
+// or BitString.
+//
+//	s, err := Build("<<4:4, IHL:4, 0:8, Len:16, Rest/binary>>", 5, 20, payload)
+func Build(pattern string, args ...interface{}) (BitString, error) {
+	segs, err := parsePattern(pattern)
+	if err != nil {
+		return BitString{}, err
+	}
+	n := 0
+	for i := range segs {
+		if segs[i].bound() {
+			n++
+		}
+	}
+	if n != len(args) {
+		return BitString{}, ErrArgCount
+	}
+
+	var bld builder
+	values := make(map[string]uint64)
+	for i := range segs {
+		seg := &segs[i]
+		size, err := seg.bits(values)
+		if err != nil {
+			return BitString{}, err
+		}
+		var arg interface{}
+		if seg.bound() {
+			arg, args = args[0], args[1:]
+		}
+
+		switch seg.typ {
+		case segInteger:
+			u := seg.literal
+			if arg != nil {
+				if u, err = intArg(arg, size, seg.signed); err != nil {
+					return BitString{}, err
+				}
+				values[seg.name] = u
+			}
+			if seg.little {
+				u = swapBytes(u, size)
+			}
+			bld.push(u, size)
+		case segFloat:
+			var u uint64
+			if arg != nil {
+				f, ok := toFloat(arg)
+				if !ok {
+					return BitString{}, ErrArgType
+				}
+				u = math.Float64bits(f)
+				if size == 32 {
+					u = uint64(math.Float32bits(float32(f)))
+				}
+			}
+			if seg.little {
+				u = swapBytes(u, size)
+			}
+			bld.push(u, size)
+		default:
+			var s BitString
+			switch a := arg.(type) {
+			case nil:
+				if size < 0 {
+					return BitString{}, ErrInvalidPattern
+				}
+				s = zeros(size)
+			case BitString:
+				s = a
+			case []byte:
+				s, _ = NewBitString(a, len(a)*8)
+			case string:
+				s, _ = NewBitString([]byte(a), len(a)*8)
+			default:
+				return BitString{}, ErrArgType
+			}
+			if size >= 0 && s.Len() != size || s.Len()%seg.unit != 0 {
+				return BitString{}, ErrArgType
+			}
+			bld.pushBits(s, 0, s.Len())
+		}
+	}
+	return bld.bitString(), nil
+}
+
+// intArg returns the lowest size bits of the integer v, which must fit in
+// them as a signed or unsigned value.
+func intArg(v interface{}, size int, signed bool) (uint64, error) {
+	rv := reflect.ValueOf(v)
+	var u uint64
+	switch rv.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u = rv.Uint()
+		if size < 64 && u>>uint(size) != 0 {
+			return 0, ErrArgType
+		}
+		return u, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := rv.Int()
+		if size < 64 {
+			min, max := int64(0), int64(1)<<uint(size)-1
+			if signed {
+				min, max = -(int64(1) << uint(size-1)), int64(1)<<uint(size-1)-1
+				if size == 0 {
+					min, max = 0, 0
+				}
+			}
+			if i < min || i > max {
+				return 0, ErrArgType
+			}
+			return uint64(i) & (1<<uint(size) - 1), nil
+		}
+		return uint64(i), nil
+	}
+	return 0, ErrArgType
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch f := v.(type) {
+	case float32:
+		return float64(f), true
+	case float64:
+		return f, true
+	}
+	return 0, false
+}
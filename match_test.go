@@ -0,0 +1,163 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	// |0100|0101|00000000|00000000,00010100|'h','i'|
+	data := []byte{0x45, 0x00, 0x00, 0x14, 'h', 'i'}
+	var ver, ihl uint8
+	var length uint16
+	var rest []byte
+	err := Match("<<Ver:4, IHL:4, _:8, Len:16, Rest/binary>>", data, &ver, &ihl, &length, &rest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ver != 4 || ihl != 5 || length != 20 || string(rest) != "hi" {
+		t.Errorf("want=4, 5, 20, hi, out: %d, %d, %d, %s", ver, ihl, length, rest)
+	}
+
+	var n uint8
+	var name []byte
+	var tail BitString
+	if err := Match("4:4, N:4, Name:N/binary, Tail/bits", []byte{0x42, 'o', 'k', 0xf0}, &n, &name, &tail); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || string(name) != "ok" || tail.String() != "0b11110000" {
+		t.Errorf("want=2, ok, 0b11110000, out: %d, %s, %v", n, name, tail)
+	}
+
+	var s int8
+	var le uint32
+	var f float32
+	var u uint16
+	data = []byte{0xf0, 0x78, 0x56, 0x34, 0x12, 0x3f, 0xc0, 0x00, 0x00, 0x03}
+	if err := Match("S:4/signed, _:4, LE:32/little, F:32/float, U:2/unit:4", data, &s, &le, &f, &u); err != nil {
+		t.Fatal(err)
+	}
+	if s != -1 || le != 0x12345678 || f != 1.5 || u != 3 {
+		t.Errorf("want=-1, 0x12345678, 1.5, 3, out: %d, %#x, %v, %d", s, le, f, u)
+	}
+}
+
+func TestMatchError(t *testing.T) {
+	var a, b uint8
+	var bs []byte
+	cases := []struct {
+		pattern string
+		data    []byte
+		dst     []interface{}
+		want    error
+	}{
+		{"A:4, B:4", []byte{0x12, 0x34}, []interface{}{&a, &b}, ErrNoMatch},
+		{"A:4, B:12", []byte{0x12}, []interface{}{&a, &b}, ErrNoMatch},
+		{"2:4, B:4", []byte{0x12}, []interface{}{&b}, ErrNoMatch},
+		{"A:4, B:4", []byte{0x12}, []interface{}{&a}, ErrArgCount},
+		{"A:12, B:4", []byte{0x12, 0x34}, []interface{}{&a, &b}, ErrArgType},
+		{"A:4, B:4", []byte{0x12}, []interface{}{a, &b}, ErrArgType},
+		{"A:4, B/binary", []byte{0x12, 0x34}, []interface{}{&a, &bs}, ErrNoMatch},
+		{"A:65", []byte{0x12}, []interface{}{&a}, ErrInvalidPattern},
+		{"A:12/little", []byte{0x12}, []interface{}{&a}, ErrInvalidPattern},
+		{"A/binary, B:8", []byte{0x12}, []interface{}{&bs, &b}, ErrInvalidPattern},
+		{"A:X", []byte{0x12}, []interface{}{&a}, ErrInvalidPattern},
+		{"A:8/text", []byte{0x12}, []interface{}{&a}, ErrInvalidPattern},
+		{"A:16/float", []byte{0x12, 0x34}, []interface{}{&a}, ErrInvalidPattern},
+		{"A:8, 256:8", []byte{0x12, 0x00}, []interface{}{&a}, ErrInvalidPattern},
+	}
+	for _, c := range cases {
+		if err := Match(c.pattern, c.data, c.dst...); err != c.want {
+			t.Errorf("%s: want=%v, out: %v", c.pattern, c.want, err)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	s, err := Build("<<4:4, IHL:4, _:8, Len:16, Rest/binary>>", 5, 20, []byte("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x45, 0x00, 0x00, 0x14, 'h', 'i'}; !reflect.DeepEqual(want, s.Bytes()) {
+		t.Errorf("want=%x, out: %x", want, s.Bytes())
+	}
+
+	tail, _ := ParseBitString("0b101")
+	s, err = Build("S:4/signed, LE:32/little, F:32/float, N:8, Name:N/binary, Tail/bits", -1, 0x12345678, 1.5, 2, "ok", tail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := ParseBitString("0xf_78563412_3fc00000_02_6f6b")
+	want = want.Concat(tail)
+	if !want.Equal(s) {
+		t.Errorf("want=%v, out: %v", want, s)
+	}
+
+	var sv int8
+	var le uint32
+	var f float64
+	var n uint8
+	var name []byte
+	var bits BitString
+	padded := s.Concat(zeros(1))
+	err = Match("S:4/signed, LE:32/little, F:32/float, N:8, Name:N/binary, Tail/bits", padded.Bytes(), &sv, &le, &f, &n, &name, &bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sv != -1 || le != 0x12345678 || f != 1.5 || n != 2 || string(name) != "ok" || bits.String() != "0b1010" {
+		t.Errorf("want=round trip, out: %d, %#x, %v, %d, %s, %v", sv, le, f, n, name, bits)
+	}
+
+	errs := []struct {
+		pattern string
+		args    []interface{}
+		want    error
+	}{
+		{"A:4", []interface{}{16}, ErrArgType},
+		{"A:4/signed", []interface{}{-9}, ErrArgType},
+		{"A:4", []interface{}{-1}, ErrArgType},
+		{"A:4", []interface{}{"x"}, ErrArgType},
+		{"A:4", nil, ErrArgCount},
+		{"A:2/binary", []interface{}{[]byte{1}}, ErrArgType},
+		{"A:8/float", []interface{}{1.0}, ErrInvalidPattern},
+		{"_/binary", nil, ErrInvalidPattern},
+		{"5:2", nil, ErrInvalidPattern},
+		{"256", nil, ErrInvalidPattern},
+		{"N:4, 16:N", []interface{}{4}, ErrInvalidPattern},
+	}
+	for _, c := range errs {
+		if _, err := Build(c.pattern, c.args...); err != c.want {
+			t.Errorf("%s: want=%v, out: %v", c.pattern, c.want, err)
+		}
+	}
+}
+
+func TestPatternCache(t *testing.T) {
+	for i := 0; i < 3*maxPatterns; i++ {
+		if _, err := Build("A:8, "+strconv.Itoa(i)+":16", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	patternCache.Lock()
+	n := len(patternCache.m)
+	patternCache.Unlock()
+	if n > maxPatterns {
+		t.Errorf("want=at most %d patterns, out: %d", maxPatterns, n)
+	}
+}
@@ -37,10 +37,21 @@ var ErrSizeTooLarge = errors.New("bitarray: Specified is too large.")
 
 // A Buffer is a variable-sized buffer of bytes with basic bit extract operations.
 type Buffer struct {
-	buf    io.ByteReader // contents should be io.ByteReader ready type.
-	n      uint8         // index of current bit position in byte segmentation.
-	extra  uint8         // extra byte unmanupilated in last operation.
-	unread bool          // flag if this buffer is unread or not.
+	buf     io.ByteReader // contents should be io.ByteReader ready type.
+	n       uint8         // index of current bit position in byte segmentation.
+	extra   uint8         // extra byte unmanupilated in last operation.
+	unread  bool          // flag if this buffer is unread or not.
+	eof     bool          // flag if buf has returned io.EOF.
+	short   bool          // flag if bits past the end of buf have been requested.
+	pos     uint64        // number of bits popped so far.
+	rec     *Writer       // receives the bits popped, if not nil.
+	crcs    []*CRC        // are fed the bits popped.
+	limit   uint64        // number of bits in buf, if limited is set.
+	limited bool          // flag if buf ends at limit instead of its last byte.
+	back    BitString     // bits given back by ScanTo, popped before buf.
+	peeked  bool          // flag if the next byte of buf has been read into next.
+	next    byte          // next byte of buf, if peeked is set.
+	nextErr error         // error reading next, if peeked is set.
 }
 
 func NewBuffer(b io.ByteReader) *Buffer {
@@ -51,21 +62,128 @@ func NewBuffer(b io.ByteReader) *Buffer {
 }
 
 // PopUint8 extract next `size` bits from Buffer. If buffer reaches tail of buffer,
-// it returns bits left in the buffer and io.EOF
+// it returns bits left in the buffer and io.EOF. If reading the buffer fails
+// otherwise, no bits are popped and the pop can be tried again.
 func (b *Buffer) PopUint8(size uint64) (uint8, error) {
+	if b.limited && size > 0 && size <= Uint8Size && b.pos+size >= b.limit {
+		return b.popLimit(size)
+	}
+	return b.pop(size)
+}
+
+// pop pops size bits and passes them to rec and crcs.
+func (b *Buffer) pop(size uint64) (uint8, error) {
+	if b.back.n > 0 {
+		return b.popBack(size)
+	}
+	bin, err := b.popUint8(size)
+	// A destuffing reader may set limit while it is read.
+	if b.limited && b.pos > b.limit && !b.short {
+		b.eof, b.short = true, true
+		if err == nil {
+			err = io.EOF
+		}
+	}
+	// Bits left before a cut short pop are recorded by popUint8.
+	if !b.short && (err == nil || err == io.EOF) {
+		b.record(bin, size)
+	}
+	return bin, err
+}
+
+// record passes the lowest size bits of bin, just popped, to rec and crcs.
+func (b *Buffer) record(bin uint8, size uint64) {
+	if b.rec != nil {
+		b.rec.PushUint8(bin, size)
+	}
+	for _, c := range b.crcs {
+		c.WriteBits(uint64(bin), int(size))
+	}
+}
+
+// popLimit pops the last bits before limit. Like the end of buf, reaching
+// limit returns io.EOF.
+func (b *Buffer) popLimit(size uint64) (uint8, error) {
+	if b.back.n == 0 && b.eof || b.pos >= b.limit {
+		b.eof, b.short = true, true
+		return 0, io.EOF
+	}
+	left := b.limit - b.pos
+	bin, err := b.pop(left)
+	if err != nil && err != io.EOF {
+		return bin, err
+	}
+	b.eof = true
+	if left < size {
+		b.short = true
+		b.pos += size - left
+	}
+	return bin, io.EOF
+}
+
+// popBack pops size bits, starting with the bits given back to b.
+func (b *Buffer) popBack(size uint64) (uint8, error) {
+	if size > Uint8Size {
+		return 0, ErrSizeTooLarge
+	}
+	k := size
+	if k > uint64(b.back.n) {
+		k = uint64(b.back.n)
+	}
+	bin := uint8(b.back.bits(0, int(k)))
+	b.back = b.back.Slice(int(k), b.back.n)
+	b.pos += k
+	b.record(bin, k)
+	if k < size {
+		low, err := b.pop(size - k)
+		return bin<<(size-k) | low, err
+	}
+	if b.back.n == 0 && b.eof {
+		return bin, io.EOF // Like the last bits of buf
+	}
+	return bin, nil
+}
+
+// unpop gives the bits s, the last ones popped, back to b.
+func (b *Buffer) unpop(s BitString) {
+	b.back = s.Concat(b.back)
+	b.pos -= uint64(s.n)
+}
+
+func (b *Buffer) popUint8(size uint64) (uint8, error) {
 	if size > Uint8Size {
 		return 0, ErrSizeTooLarge
 	}
+	if b.eof && size > 0 {
+		b.short = true
+		return 0, io.EOF
+	}
+	b.pos += size
 
 	if b.unread {
-		c, err := b.buf.ReadByte()
+		c, err := b.readByte()
 		if err != nil { // Including io.EOF
+			if err == io.EOF {
+				b.eof = true
+				b.short = true
+			} else {
+				b.pos -= size
+			}
 			return 0, err
 		}
 		bin := uint8(c) >> (Uint8Size - size)
 		if size == Uint8Size {
-			c, err = b.buf.ReadByte()
+			b.unread = false
+			c, err = b.readByte()
+			if err == io.EOF {
+				b.eof = true
+				b.extra = uint8(0x00)
+				return bin, err
+			}
 			if err != nil {
+				// Keep the first byte to be popped again.
+				b.pos -= size
+				b.n, b.extra = 0, bin
 				return 0, err
 			}
 			b.extra = uint8(c)
@@ -77,26 +195,32 @@ func (b *Buffer) PopUint8(size uint64) (uint8, error) {
 		return bin, nil
 	} else if uint64(b.n)+size == Uint8Size {
 		bin := b.extra >> b.n
-		c, err := b.buf.ReadByte()
-		b.n = 0
+		c, err := b.readByte()
 		if err == io.EOF {
-			b.extra = uint8(0x00)
+			b.eof = true
+			b.n, b.extra = 0, 0x00
 			return bin, err
 		}
 		if err != nil {
+			// Leave the bits to be popped again after the error.
+			b.pos -= size
 			return 0, err
 		}
-		b.extra = uint8(c)
+		b.n, b.extra = 0, uint8(c)
 		return bin, nil
 	} else if uint64(b.n)+size > Uint8Size {
-		c, err := b.buf.ReadByte()
+		c, err := b.readByte()
 		if err == io.EOF {
+			b.eof = true
+			b.short = true
 			bin := b.extra >> b.n
+			b.record(bin, Uint8Size-uint64(b.n))  // Bits left in the buffer
 			b.n += uint8(size) - uint8(Uint8Size) // Add overflowed bit size
 			b.extra = 0x00
 			return bin, io.EOF
 		}
 		if err != nil {
+			b.pos -= size
 			return 0, err
 		}
 		n := (uint64(b.n) + size) % Uint8Size
@@ -113,6 +237,84 @@ func (b *Buffer) PopUint8(size uint64) (uint8, error) {
 	}
 }
 
+// AtEOF reports whether all bits in the Buffer have been popped. If nothing
+// has been popped yet, it reads ahead the first byte to find out.
+func (b *Buffer) AtEOF() (bool, error) {
+	if b.back.n > 0 {
+		return false, nil
+	}
+	if b.limited && b.pos >= b.limit {
+		return true, nil
+	}
+	if !b.unread {
+		return b.eof, nil
+	}
+	c, err := b.readByte()
+	if err == io.EOF {
+		b.eof = true
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	b.extra = uint8(c)
+	b.n = 0
+	b.unread = false
+	return false, nil
+}
+
+// readByte reads the next byte of buf, or returns the one read ahead by
+// atPadding.
+func (b *Buffer) readByte() (byte, error) {
+	if b.peeked {
+		b.peeked = false
+		return b.next, b.nextErr
+	}
+	return b.buf.ReadByte()
+}
+
+// atPadding reports whether the bits left in the current byte are the last
+// bits of buf, so that they only pad a record that did not end on a byte
+// border. Bits before the limit of a limited Buffer are never padding.
+func (b *Buffer) atPadding() (bool, error) {
+	if b.back.n > 0 || b.unread || b.eof || b.limited || b.n == 0 {
+		return false, nil
+	}
+	if !b.peeked {
+		c, err := b.buf.ReadByte()
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		b.peeked, b.next, b.nextErr = true, c, err
+	}
+	return b.nextErr == io.EOF, nil
+}
+
+// Pos returns the number of bits popped from the Buffer so far.
+func (b *Buffer) Pos() uint64 {
+	return b.pos
+}
+
+// Truncated reports whether bits past the end of the Buffer have been
+// requested, so that the value of some pop was cut short.
+func (b *Buffer) Truncated() bool {
+	return b.short
+}
+
+// Align skips the bits left in the current byte, so that the next pop starts
+// on a byte border.
+func (b *Buffer) Align() error {
+	r := b.pos % Uint8Size
+	if r == 0 {
+		return nil
+	}
+	_, err := b.PopUint8(Uint8Size - r)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
 // PopUint16 extract next `size` bits from Buffer. If buffer reaches tail of buffer,
 // it returns bits left in the buffer and io.EOF
 func (b *Buffer) PopUint16(size uint64) (uint16, error) {
@@ -128,6 +330,7 @@ func (b *Buffer) PopUint16(size uint64) (uint16, error) {
 		if err != nil {
 			if err == io.EOF {
 				b.n += uint8(size - Uint8Size) // Add overflowed bit size
+				b.short = true
 			}
 			return uint16(bin), err
 		}
@@ -135,7 +338,7 @@ func (b *Buffer) PopUint16(size uint64) (uint16, error) {
 		bin16 := uint16(bin) << leftSize
 
 		bin8, err := b.PopUint8(leftSize)
-		if err == io.EOF {
+		if err == io.EOF && b.short {
 			bin16 = bin16 >> b.n
 		}
 		return bin16 + uint16(bin8), err
@@ -161,6 +364,7 @@ func (b *Buffer) PopUint32(size uint64) (uint32, error) {
 	if err != nil {
 		if err == io.EOF {
 			b.n += uint8(size - Uint16Size)
+			b.short = true
 		}
 		return uint32(bin), err
 	}
@@ -169,7 +373,7 @@ func (b *Buffer) PopUint32(size uint64) (uint32, error) {
 	if leftSize <= Uint8Size {
 		bin32 := uint32(bin) << leftSize
 		bin8, err := b.PopUint8(leftSize)
-		if err == io.EOF {
+		if err == io.EOF && b.short {
 			bin32 = bin32 >> b.n
 		}
 		return bin32 + uint32(bin8), err
@@ -177,7 +381,7 @@ func (b *Buffer) PopUint32(size uint64) (uint32, error) {
 
 	bin32 := uint32(bin) << leftSize
 	bin16, err := b.PopUint16(leftSize)
-	if err == io.EOF {
+	if err == io.EOF && b.short {
 		bin32 = bin32 >> b.n
 	}
 	return bin32 + uint32(bin16), err
@@ -204,6 +408,7 @@ func (b *Buffer) PopUint64(size uint64) (uint64, error) {
 	if err != nil {
 		if err == io.EOF {
 			b.n += uint8(size - Uint32Size)
+			b.short = true
 		}
 		return uint64(bin), err
 	}
@@ -212,14 +417,14 @@ func (b *Buffer) PopUint64(size uint64) (uint64, error) {
 	if leftSize <= Uint8Size {
 		bin64 := uint64(bin) << leftSize
 		bin8, err := b.PopUint8(leftSize)
-		if err == io.EOF {
+		if err == io.EOF && b.short {
 			bin64 = bin64 >> b.n
 		}
 		return bin64 + uint64(bin8), err
 	} else if leftSize <= Uint16Size {
 		bin64 := uint64(bin) << leftSize
 		bin16, err := b.PopUint16(leftSize)
-		if err == io.EOF {
+		if err == io.EOF && b.short {
 			bin64 = bin64 >> b.n
 		}
 		return bin64 + uint64(bin16), err
@@ -227,7 +432,7 @@ func (b *Buffer) PopUint64(size uint64) (uint64, error) {
 
 	bin64 := uint64(bin) << leftSize
 	bin32, err := b.PopUint32(leftSize)
-	if err == io.EOF {
+	if err == io.EOF && b.short {
 		bin64 = bin64 >> b.n
 	}
 	return bin64 + uint64(bin32), err
@@ -240,6 +445,9 @@ func (b *Buffer) PopBytes(size uint64) ([]byte, error) {
 	for i := uint64(0); i < size; i++ {
 		byt, err := b.PopUint8(Uint8Size)
 		if err != nil {
+			if err == io.EOF && i+1 < size {
+				b.short = true
+			}
 			bytes = append(bytes, byt)
 			return bytes, err
 		}
@@ -247,3 +455,50 @@ func (b *Buffer) PopBytes(size uint64) ([]byte, error) {
 	}
 	return bytes, nil
 }
+
+// A bitReader is an io.ByteReader of the bits returned by next, for Buffers
+// that transform bits rather than bytes, such as the destuffing and line
+// code readers.
+type bitReader struct {
+	next func() (uint64, uint64, error) // returns some bits and their count.
+	out  *Buffer                        // the Buffer reading from bitReader.
+	n    uint64                         // number of bits read so far.
+	pend uint64                         // bits returned by next not read yet.
+	pn   uint64                         // number of bits in pend.
+}
+
+// newBitReader returns a Buffer of the bits returned by next, up to 8 at a
+// time, until it returns io.EOF. The Buffer ends with the last bit, even
+// within a byte.
+func newBitReader(next func() (uint64, uint64, error)) *Buffer {
+	r := &bitReader{next: next}
+	r.out = NewBuffer(r)
+	return r.out
+}
+
+func (r *bitReader) ReadByte() (byte, error) {
+	for r.pn < Uint8Size {
+		v, k, err := r.next()
+		if err == io.EOF {
+			if r.pn == 0 {
+				return 0, io.EOF
+			}
+			// End the Buffer at the last bit of a partial byte.
+			r.n += r.pn
+			r.out.limit, r.out.limited = r.n, true
+			c := byte(r.pend << (Uint8Size - r.pn))
+			r.pend, r.pn = 0, 0
+			return c, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.pend = r.pend<<k | v&(1<<k-1)
+		r.pn += k
+	}
+	r.pn -= Uint8Size
+	r.n += Uint8Size
+	c := byte(r.pend >> r.pn)
+	r.pend &= 1<<r.pn - 1
+	return c, nil
+}
@@ -1045,3 +1045,131 @@ func TestPopBytesCase1(t *testing.T) {
 		}
 	}
 }
+
+// PopUint8: Case 7) Fetch 8 bits from a single byte array.
+// Use case that the only byte is fetched from head of []byte.
+// 1. |[10100101]|
+func TestPopUint8Case7(t *testing.T) {
+	b := NewBuffer(bytes.NewBuffer([]byte{0xa5}))
+	eof, err := b.AtEOF()
+	if eof || err != nil {
+		t.Errorf("AtEOF before pop: want: false, out=%v, %v", eof, err)
+	}
+	out, err := b.PopUint8(8)
+	if err != io.EOF {
+		t.Errorf("want: io.EOF, out=%v", err)
+	}
+	if out != 0xa5 {
+		t.Errorf("want: %x, out=%x", 0xa5, out)
+	}
+	eof, err = b.AtEOF()
+	if !eof || err != nil {
+		t.Errorf("AtEOF after pop: want: true, out=%v, %v", eof, err)
+	}
+}
+
+// Align: Skip to the next byte border, and report truncated pops.
+// |101|00000|11110000|
+func TestAlign(t *testing.T) {
+	b := NewBuffer(bytes.NewBuffer([]byte{0xa0, 0xf0}))
+	if err := b.Align(); err != nil {
+		t.Error(err)
+	}
+	out, _ := b.PopUint8(3)
+	if out != 0x05 {
+		t.Errorf("want: %x, out=%x", 0x05, out)
+	}
+	if err := b.Align(); err != nil {
+		t.Error(err)
+	}
+	out, _ = b.PopUint8(4)
+	if out != 0x0f {
+		t.Errorf("want: %x, out=%x", 0x0f, out)
+	}
+	if b.Truncated() {
+		t.Error("want: not truncated")
+	}
+	if err := b.Align(); err != nil {
+		t.Error(err)
+	}
+	if eof, _ := b.AtEOF(); !eof {
+		t.Error("want: EOF after align")
+	}
+	b.PopUint8(1)
+	if !b.Truncated() {
+		t.Error("want: truncated")
+	}
+}
+
+// bitsAt returns size bits of data starting at bit offset pos.
+func bitsAt(data []byte, pos, size uint64) uint64 {
+	var v uint64
+	for i := pos; i < pos+size; i++ {
+		v = v<<1 | uint64(data[i/8]>>(7-i%8)&1)
+	}
+	return v
+}
+
+// FuzzPopUint64 pops bits in sizes taken from sizes and compares them with
+// the bits of data at the same position. Run with go test -fuzz=FuzzPopUint64.
+func FuzzPopUint64(f *testing.F) {
+	f.Add([]byte{0x00, 0xff, 0x00, 0xff}, []byte{3, 8, 13})
+	f.Add([]byte{0xf0, 0x0f, 0xf0, 0x0f, 0xf0, 0x0f, 0xf0, 0x0f, 0xf0}, []byte{1, 64, 7})
+	f.Add([]byte{0xaa, 0x55, 0xaa, 0x55, 0xaa}, []byte{16, 9, 15, 0})
+	f.Fuzz(func(t *testing.T, data []byte, sizes []byte) {
+		b := NewBuffer(bytes.NewBuffer(data))
+		total := uint64(len(data)) * Uint8Size
+		pos := uint64(0)
+		for _, s := range sizes {
+			size := uint64(s) % (Uint64Size + 1)
+			if size == 0 {
+				continue
+			}
+			out, err := b.PopUint64(size)
+			if pos+size > total {
+				if err != io.EOF {
+					t.Fatalf("pop %d bits at %d of %d: want io.EOF, out=%v", size, pos, total, err)
+				}
+				return
+			}
+			if err != nil && !(err == io.EOF && pos+size == total) {
+				t.Fatalf("pop %d bits at %d of %d: %v", size, pos, total, err)
+			}
+			if want := bitsAt(data, pos, size); out != want {
+				t.Fatalf("pop %d bits at %d: want: %x, out=%x", size, pos, want, out)
+			}
+			pos += size
+		}
+	})
+}
+
+// FuzzPopBytes pops size bytes after skipping skip bits and compares them
+// with the bytes of data at the same position.
+func FuzzPopBytes(f *testing.F) {
+	f.Add([]byte("Hello, world"), uint8(2), uint8(5))
+	f.Add([]byte{0x01}, uint8(0), uint8(1))
+	f.Fuzz(func(t *testing.T, data []byte, skip uint8, size uint8) {
+		skip %= 8
+		b := NewBuffer(bytes.NewBuffer(data))
+		if skip > 0 {
+			if _, err := b.PopUint8(uint64(skip)); err != nil {
+				return
+			}
+		}
+		out, err := b.PopBytes(uint64(size))
+		if uint64(skip)+uint64(size)*8 > uint64(len(data))*8 {
+			if err != io.EOF {
+				t.Fatalf("want io.EOF, out=%v", err)
+			}
+			return
+		}
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		for i, byt := range out {
+			if want := bitsAt(data, uint64(skip)+uint64(i)*8, 8); uint64(byt) != want {
+				t.Fatalf("%dth byte: want: %x, out=%x", i, want, byt)
+			}
+		}
+	})
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// An UnknownKindPolicy controls how a Decoder handles tagged fields of types
+// it cannot decode.
+type UnknownKindPolicy int
+
+const (
+	UnknownKindError    UnknownKindPolicy = iota // fail with a *FieldError
+	UnknownKindSkip                              // skip the bits of the field
+	UnknownKindFallback                          // call DecoderOptions.Fallback
+)
+
+// A Logger receives diagnostic messages from a Decoder. *log.Logger
+// satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Default limits of a Decoder, used where DecoderOptions leaves them zero.
+const (
+	DefaultMaxSliceLen = 1 << 24
+	DefaultMaxDepth    = 64
+)
+
+// DecoderOptions controls the behavior of a Decoder. The zero value is the
+// default: fields of unsupported types are errors, fields cut short by the
+// end of the buffer are zero filled, slices and nesting are limited to
+// DefaultMaxSliceLen and DefaultMaxDepth, and nothing is logged.
+//
+// MaxBytes and MaxBits count over all the records a Decoder decodes, so
+// they have no default; callers decoding untrusted data should set them.
+type DecoderOptions struct {
+	// UnknownKind selects what to do with tagged fields of unsupported types.
+	UnknownKind UnknownKindPolicy
+
+	// Fallback decodes a field of unsupported type from b into v when
+	// UnknownKind is UnknownKindFallback.
+	Fallback func(b *Buffer, f reflect.StructField, v reflect.Value) error
+
+	// StrictEOF makes fields cut short by the end of the buffer fail with
+	// io.ErrUnexpectedEOF.
+	StrictEOF bool
+
+	// MaxSliceLen limits the number of elements of slice fields and string
+	// fields. 0 means DefaultMaxSliceLen, and a negative value no limit.
+	MaxSliceLen int
+
+	// MaxBytes limits the total number of bytes allocated for slice and
+	// string fields. 0 means no limit.
+	MaxBytes int
+
+	// MaxDepth limits the nesting depth of structs decoded from slice
+	// fields. The struct passed to Unmarshal is at depth 1. 0 means
+	// DefaultMaxDepth, and a negative value no limit.
+	MaxDepth int
+
+	// MaxBits limits the total number of bits the Decoder pops from its
+	// buffer. 0 means no limit.
+	MaxBits int
+
+	// Logger receives messages about skipped and truncated fields. If nil,
+	// messages are discarded.
+	Logger Logger
+}
+
+// A LimitError is returned when decoding would exceed a limit set in
+// DecoderOptions.
+type LimitError struct {
+	Limit string // name of the DecoderOptions field
+	Max   int64  // value of the limit
+}
+
+func (e *LimitError) Error() string {
+	return "bitarray: " + e.Limit + " of " + strconv.FormatInt(e.Max, 10) + " exceeded"
+}
+
+func (d *Decoder) logf(format string, v ...interface{}) {
+	if d.opts.Logger != nil {
+		d.opts.Logger.Printf(format, v...)
+	}
+}
+
+// orDefault returns the limit max, or def if max is 0.
+func orDefault(max, def int) int {
+	if max == 0 {
+		return def
+	}
+	return max
+}
+
+// checkSliceLen returns a *LimitError if a slice of n elements exceeds
+// MaxSliceLen.
+func (d *Decoder) checkSliceLen(n uint64) error {
+	if max := orDefault(d.opts.MaxSliceLen, DefaultMaxSliceLen); max > 0 && n > uint64(max) {
+		return &LimitError{Limit: "MaxSliceLen", Max: int64(max)}
+	}
+	return nil
+}
+
+// alloc accounts for n bytes allocated for a field and returns a
+// *LimitError if they exceed MaxBytes.
+func (d *Decoder) alloc(n uint64) error {
+	d.allocated += n
+	if max := d.opts.MaxBytes; max > 0 && d.allocated > uint64(max) {
+		return &LimitError{Limit: "MaxBytes", Max: int64(max)}
+	}
+	return nil
+}
+
+// enter increments the nesting depth of structs being decoded and returns a
+// *LimitError if it exceeds MaxDepth. Each call must be paired with leave.
+func (d *Decoder) enter() error {
+	d.depth++
+	if max := orDefault(d.opts.MaxDepth, DefaultMaxDepth); max > 0 && d.depth > max {
+		return &LimitError{Limit: "MaxDepth", Max: int64(max)}
+	}
+	return nil
+}
+
+func (d *Decoder) leave() {
+	d.depth--
+}
+
+// checkBits returns a *LimitError if popping next more bits would exceed
+// MaxBits.
+func (d *Decoder) checkBits(next uint64) error {
+	if max := d.opts.MaxBits; max > 0 && d.buf.pos-d.start+next > uint64(max) {
+		return &LimitError{Limit: "MaxBits", Max: int64(max)}
+	}
+	return nil
+}
+
+// decodeUnknown handles the field f of r, whose type the decoder does not
+// support, according to the UnknownKind policy.
+func (d *Decoder) decodeUnknown(l *StructLayout, r record, f *FieldLayout) error {
+	switch d.opts.UnknownKind {
+	case UnknownKindSkip:
+		d.logf("bitarray: skipping %d bits of field %s of unsupported type %s", f.Bits, f.Name, r.fieldType(f))
+		err := d.skip(uint64(f.Bits))
+		if err == io.ErrUnexpectedEOF {
+			return &FieldError{Type: l.Type, Field: f.Name, Err: err}
+		}
+		return err
+	case UnknownKindFallback:
+		if sr, ok := r.(structRecord); ok && d.opts.Fallback != nil {
+			return d.opts.Fallback(d.buf, l.Type.Field(f.Index), sr.v.Field(f.Index))
+		}
+	}
+	return &FieldError{Type: l.Type, Field: f.Name, Err: ErrUnsupportedFieldType}
+}
+
+// skip pops size bits and throws them away. It stops at the end of the
+// buffer, which is an io.ErrUnexpectedEOF with StrictEOF if bits are left.
+func (d *Decoder) skip(size uint64) error {
+	for size > 0 {
+		n := size
+		if n > Uint64Size {
+			n = Uint64Size
+		}
+		_, err := d.buf.PopUint64(n)
+		if err == io.EOF {
+			if (size > n || d.buf.short) && d.opts.StrictEOF {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		size -= n
+	}
+	return nil
+}
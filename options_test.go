@@ -0,0 +1,241 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type testLogger struct {
+	msgs []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.msgs = append(l.msgs, fmt.Sprintf(format, v...))
+}
+
+type unknownS struct {
+	F1 uint8 `bits:"4"`
+	F2 int   `bits:"4"`
+	F3 uint8 `bits:"8"`
+}
+
+var unknownData = []byte{
+	0x1e, // 0001|1110
+	0x03, // 0000,0011
+}
+
+func TestDecoderOptionsUnknownKindError(t *testing.T) {
+	d := NewDecoder(NewBuffer(bytes.NewBuffer(unknownData)))
+	err := d.Unmarshal(&unknownS{})
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Field != "F2" || fieldErr.Err != ErrUnsupportedFieldType {
+		t.Errorf("want *FieldError on F2, out: %v", err)
+	}
+}
+
+func TestDecoderOptionsUnknownKindSkip(t *testing.T) {
+	logger := &testLogger{}
+	d := NewDecoder(NewBuffer(bytes.NewBuffer(unknownData)), DecoderOptions{
+		UnknownKind: UnknownKindSkip,
+		Logger:      logger,
+	})
+	out := &unknownS{}
+	if err := d.Unmarshal(out); err != nil {
+		t.Fatal(err)
+	}
+	want := &unknownS{F1: 0x1, F3: 0x03}
+	if !reflect.DeepEqual(want, out) {
+		t.Errorf("want=%#v, out: %#v", want, out)
+	}
+	if len(logger.msgs) != 1 {
+		t.Errorf("want 1 message, out: %q", logger.msgs)
+	}
+}
+
+func TestDecoderOptionsUnknownKindFallback(t *testing.T) {
+	d := NewDecoder(NewBuffer(bytes.NewBuffer(unknownData)), DecoderOptions{
+		UnknownKind: UnknownKindFallback,
+		Fallback: func(b *Buffer, f reflect.StructField, v reflect.Value) error {
+			bin, err := b.PopUint8(4)
+			// Sign extend 4 bits two's complement.
+			v.SetInt(int64(int8(bin<<4) >> 4))
+			return err
+		},
+	})
+	out := &unknownS{}
+	if err := d.Unmarshal(out); err != nil {
+		t.Fatal(err)
+	}
+	want := &unknownS{F1: 0x1, F2: -2, F3: 0x03}
+	if !reflect.DeepEqual(want, out) {
+		t.Errorf("want=%#v, out: %#v", want, out)
+	}
+}
+
+func TestDecoderOptionsUnknownKindSkipEOF(t *testing.T) {
+	type S struct {
+		F1 uint8   `bits:"8"`
+		F2 float64 `bits:"100000000000000"`
+	}
+
+	// Skipping stops at the end of the buffer instead of popping on.
+	d := NewDecoder(NewBuffer(bytes.NewBuffer(unknownData)), DecoderOptions{UnknownKind: UnknownKindSkip})
+	out := &S{}
+	if err := d.Unmarshal(out); err != nil || out.F1 != 0x1e {
+		t.Errorf("want=0x1e, out: %#v, %v", out.F1, err)
+	}
+
+	d = NewDecoder(NewBuffer(bytes.NewBuffer(unknownData)), DecoderOptions{UnknownKind: UnknownKindSkip, StrictEOF: true})
+	err := d.Unmarshal(&S{})
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Field != "F2" || fieldErr.Err != io.ErrUnexpectedEOF {
+		t.Errorf("want *FieldError on F2, out: %v", err)
+	}
+}
+
+func TestDecoderOptionsStrictEOF(t *testing.T) {
+	type S struct {
+		F1 uint8  `bits:"8"`
+		F2 uint16 `bits:"16"`
+	}
+
+	data := []byte{0x01, 0x02}
+	lenient := NewDecoder(NewBuffer(bytes.NewBuffer(data)))
+	if err := lenient.Unmarshal(&S{}); err != nil {
+		t.Errorf("lenient: want no error, out: %v", err)
+	}
+
+	strict := NewDecoder(NewBuffer(bytes.NewBuffer(data)), DecoderOptions{StrictEOF: true})
+	err := strict.Unmarshal(&S{})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("strict: want=%v, out: %v", io.ErrUnexpectedEOF, err)
+	}
+
+	exact := NewDecoder(NewBuffer(bytes.NewBuffer([]byte{0x01, 0x02, 0x03})), DecoderOptions{StrictEOF: true})
+	if err := exact.Unmarshal(&S{}); err != nil {
+		t.Errorf("strict, exact size: want no error, out: %v", err)
+	}
+}
+
+func TestDecoderOptionsMaxSliceLen(t *testing.T) {
+	type S struct {
+		N    uint8  `bits:"8"`
+		Data []byte `count:"N"`
+	}
+
+	d := NewDecoder(NewBuffer(bytes.NewBuffer([]byte{0x05, 1, 2, 3, 4, 5})), DecoderOptions{MaxSliceLen: 4})
+	err := d.Unmarshal(&S{})
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "MaxSliceLen" {
+		t.Errorf("want *LimitError, out: %v", err)
+	}
+}
+
+func TestDecoderOptionsLimits(t *testing.T) {
+	type Node struct {
+		N        uint8  `bits:"8"`
+		Children []Node `count:"N"`
+	}
+	type S struct {
+		N    uint8  `bits:"8"`
+		Data []byte `count:"N"`
+		Name string `binary:"zstring"`
+	}
+
+	cases := []struct {
+		limit string
+		opts  DecoderOptions
+		data  []byte
+		v     interface{}
+	}{
+		{"MaxDepth", DecoderOptions{MaxDepth: 3}, []byte{1, 1, 1, 0}, &Node{}},
+		{"MaxBytes", DecoderOptions{MaxBytes: 4}, []byte{3, 1, 2, 3, 'a', 'b', 0}, &S{}},
+		{"MaxBits", DecoderOptions{MaxBits: 32}, []byte{3, 1, 2, 3, 'a', 'b', 0}, &S{}},
+	}
+	for _, c := range cases {
+		d := NewDecoder(NewBuffer(bytes.NewBuffer(c.data)), c.opts)
+		err := d.Unmarshal(c.v)
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != c.limit {
+			t.Errorf("%s: want *LimitError, out: %v", c.limit, err)
+		}
+	}
+
+	// The same data decode within generous limits.
+	d := NewDecoder(NewBuffer(bytes.NewBuffer([]byte{1, 1, 1, 0})), DecoderOptions{MaxDepth: 4, MaxBits: 32, MaxBytes: 128})
+	if err := d.Unmarshal(&Node{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecoderDefaultLimits(t *testing.T) {
+	type Node struct {
+		N        uint8  `bits:"8"`
+		Children []Node `count:"N"`
+	}
+
+	// A chain of 100 nodes nests deeper than DefaultMaxDepth.
+	data := append(bytes.Repeat([]byte{1}, 99), 0)
+	err := NewDecoder(NewBuffer(bytes.NewBuffer(data))).Unmarshal(&Node{})
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "MaxDepth" || limitErr.Max != DefaultMaxDepth {
+		t.Errorf("want *LimitError for MaxDepth, out: %v", err)
+	}
+
+	d := NewDecoder(NewBuffer(bytes.NewBuffer(data)), DecoderOptions{MaxDepth: -1})
+	if err := d.Unmarshal(&Node{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecoderNoProgress(t *testing.T) {
+	type E struct {
+		F1 []byte `count:"0"`
+	}
+	type S struct {
+		F1 []E `until:"eof"`
+	}
+
+	d := NewDecoder(NewBuffer(bytes.NewBuffer([]byte{0x01})))
+	if err := d.Unmarshal(&S{}); err != ErrNoProgress {
+		t.Errorf("want=%v, out: %v", ErrNoProgress, err)
+	}
+}
+
+func TestDecoderNoProgressCount(t *testing.T) {
+	type E struct {
+		F1 uint8 `bits:"0"`
+	}
+	type S struct {
+		N  uint32 `bits:"32"`
+		F1 []E    `count:"N"`
+		F2 uint8  `bits:"8"`
+	}
+
+	// A huge count of empty elements is rejected rather than allocated,
+	// even without a limit on the slice length.
+	data := []byte{0xff, 0xff, 0xff, 0xff, 0x01}
+	d := NewDecoder(NewBuffer(bytes.NewBuffer(data)), DecoderOptions{MaxSliceLen: -1})
+	if err := d.Unmarshal(&S{}); err != ErrNoProgress {
+		t.Errorf("want=%v, out: %v", ErrNoProgress, err)
+	}
+}
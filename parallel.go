@@ -0,0 +1,127 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// ErrDynamicLayout is returned when a struct of dynamic size is decoded in a
+// way that requires a static size.
+var ErrDynamicLayout = errors.New("bitarray: size of struct depends on decoded data")
+
+// A RecordError records the index of a record that failed to decode.
+type RecordError struct {
+	Index int
+	Err   error
+}
+
+func (e *RecordError) Error() string {
+	return "bitarray: record " + strconv.Itoa(e.Index) + ": " + e.Err.Error()
+}
+
+func (e *RecordError) Unwrap() error { return e.Err }
+
+// DecodeParallel decodes data as a sequence of records of type T using up
+// to workers goroutines. The size of T must be static, so that each record
+// can be located without decoding the records before it. If workers is not
+// positive, GOMAXPROCS is used. Records are returned in order; on failure
+// the error is a *RecordError for the first record that failed.
+//
+// At most one DecoderOptions may be given, as to NewDecoder. Its limits
+// apply to each record, its Logger and Fallback must be safe for concurrent
+// use, and Fallback must consume the bits given by the tag of its field.
+func DecodeParallel[T any](data []byte, workers int, opts ...DecoderOptions) ([]T, error) {
+	var o DecoderOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	l, err := layoutOf(reflect.TypeOf((*T)(nil)).Elem())
+	if err == nil && o.UnknownKind == UnknownKindError {
+		err = l.validate()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !l.Static || l.Bits == 0 {
+		return nil, ErrDynamicLayout
+	}
+	size := uint64(l.Bits)
+
+	total := uint64(len(data)) * Uint8Size
+	n := int(total / size)
+	if total-uint64(n)*size >= Uint8Size {
+		return nil, &RecordError{Index: n, Err: io.ErrUnexpectedEOF}
+	}
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > n {
+		workers = n
+	}
+
+	records := make([]T, n)
+	errs := make([]*RecordError, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		lo, hi := n*w/workers, n*(w+1)/workers
+		wg.Add(1)
+		go func(w, lo, hi int) {
+			defer wg.Done()
+			if err := decodeRecords(data, size, records[lo:hi], lo, o); err != nil {
+				errs[w] = err
+			}
+		}(w, lo, hi)
+	}
+	wg.Wait()
+
+	// Chunks are in record order, so the first error is the first failure.
+	for _, err := range errs {
+		if err != nil {
+			return records, err
+		}
+	}
+	return records, nil
+}
+
+// decodeRecords decodes consecutive records of size bits, starting with
+// the record at index first, into records with the options opts.
+func decodeRecords[T any](data []byte, size uint64, records []T, first int, opts DecoderOptions) *RecordError {
+	off := uint64(first) * size
+	b := NewBuffer(bytes.NewReader(data[off/Uint8Size:]))
+	if skip := off % Uint8Size; skip > 0 {
+		if _, err := b.PopUint8(skip); err != nil {
+			return &RecordError{Index: first, Err: err}
+		}
+	}
+	d := NewDecoder(b, opts)
+	for i := range records {
+		// Limits count from the start of each record, so that they do
+		// not depend on how records are split between workers.
+		d.start, d.allocated = b.pos, 0
+		if err := d.decodeStruct(reflect.ValueOf(&records[i]).Elem()); err != nil {
+			return &RecordError{Index: first + i, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeParallel(t *testing.T) {
+	type S struct {
+		F1 uint8  `bits:"4"`
+		F2 uint16 `bits:"8"`
+	}
+
+	// Records are 12 bits long, so every other record starts in the middle
+	// of a byte. A pair of records a and b is packed into 3 bytes:
+	// |aaaaAAAA|AAAAbbbb|BBBBBBBB|
+	var data []byte
+	var want []S
+	for i := 0; i < 100; i += 2 {
+		a := S{F1: uint8(i % 16), F2: uint16(i)}
+		b := S{F1: uint8((i + 1) % 16), F2: uint16(255 - i)}
+		data = append(data,
+			a.F1<<4|uint8(a.F2>>4),
+			uint8(a.F2)<<4|b.F1,
+			uint8(b.F2))
+		want = append(want, a, b)
+	}
+
+	for _, workers := range []int{0, 1, 3, 200} {
+		out, err := DecodeParallel[S](data, workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want, out) {
+			t.Errorf("workers=%d: want=%v, out: %v", workers, want, out)
+		}
+	}
+}
+
+func TestDecodeParallelError(t *testing.T) {
+	type State uint8
+	type S struct {
+		F1 State `bits:"8" enum:"0=Idle,1=Run"`
+	}
+
+	_, err := DecodeParallel[S]([]byte{0x00, 0x01, 0x01, 0x02, 0x00, 0x03}, 2)
+	var recErr *RecordError
+	if !errors.As(err, &recErr) {
+		t.Fatalf("want *RecordError, out: %v", err)
+	}
+	if recErr.Index != 3 {
+		t.Errorf("want index 3, out: %d", recErr.Index)
+	}
+	var enumErr *EnumError
+	if !errors.As(err, &enumErr) {
+		t.Errorf("want *EnumError, out: %v", recErr.Err)
+	}
+}
+
+func TestDecodeParallelTruncated(t *testing.T) {
+	type S struct {
+		F1 uint16 `bits:"16"`
+	}
+
+	_, err := DecodeParallel[S]([]byte{0x00, 0x01, 0x02}, 2)
+	var recErr *RecordError
+	if !errors.As(err, &recErr) || recErr.Index != 1 || recErr.Err != io.ErrUnexpectedEOF {
+		t.Errorf("want record 1 truncated, out: %v", err)
+	}
+}
+
+func TestDecodeParallelDynamic(t *testing.T) {
+	type S struct {
+		F1 string `binary:"zstring"`
+	}
+
+	if _, err := DecodeParallel[S]([]byte{0x00}, 2); err != ErrDynamicLayout {
+		t.Errorf("want=%v, out: %v", ErrDynamicLayout, err)
+	}
+}
+
+func TestDecodeParallelOptions(t *testing.T) {
+	type S struct {
+		F1 []byte `binary:"2"`
+		F2 int    `bits:"8"`
+	}
+	data := []byte{0x01, 0x02, 0xff, 0x03, 0x04, 0xff, 0x05, 0x06, 0xff}
+
+	if _, err := DecodeParallel[S](data, 2); !errors.Is(err, ErrUnsupportedFieldType) {
+		t.Errorf("want=%v, out: %v", ErrUnsupportedFieldType, err)
+	}
+
+	// Limits apply to each record, whatever the number of workers.
+	opts := DecoderOptions{UnknownKind: UnknownKindSkip, MaxBytes: 2}
+	for _, workers := range []int{1, 3} {
+		out, err := DecodeParallel[S](data, workers, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []byte{0x05, 0x06}; len(out) != 3 || !reflect.DeepEqual(want, out[2].F1) {
+			t.Errorf("workers=%d: want=%x, out: %v", workers, want, out)
+		}
+	}
+
+	opts.MaxBytes = 1
+	_, err := DecodeParallel[S](data, 3, opts)
+	var limitErr *LimitError
+	var recErr *RecordError
+	if !errors.As(err, &recErr) || recErr.Index != 0 || !errors.As(err, &limitErr) {
+		t.Errorf("want *LimitError on record 0, out: %v", err)
+	}
+}
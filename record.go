@@ -0,0 +1,167 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"reflect"
+)
+
+// A record is the destination of decoded fields. The same layout engine
+// decodes into structs through structRecord and into maps through mapRecord.
+type record interface {
+	setUint(f *FieldLayout, v uint64)
+	setBool(f *FieldLayout, v bool)
+	setBytes(f *FieldLayout, v []byte)
+	setString(f *FieldLayout, v string)
+
+	// makeSlice sets the SliceKind field f to an empty slice, elem returns
+	// a new record for an element of f, and appendElem appends it to f.
+	makeSlice(f *FieldLayout)
+	elem(f *FieldLayout) record
+	appendElem(f *FieldLayout, elem record)
+
+	// uint returns the value of the UintKind field named name.
+	uint(name string) (uint64, bool)
+
+	// fieldType returns the Go type of f, or nil if there is none.
+	fieldType(f *FieldLayout) reflect.Type
+
+	// elemSize returns the approximate number of bytes an element of the
+	// SliceKind field f occupies.
+	elemSize(f *FieldLayout) uint64
+}
+
+// structRecord decodes fields into a struct, where FieldLayout.Index is the
+// index of the struct field. Fields named "_" are left untouched.
+type structRecord struct {
+	v reflect.Value
+}
+
+func (r structRecord) setUint(f *FieldLayout, v uint64) {
+	if f.Name == "_" {
+		return
+	}
+	if f.Signed {
+		r.v.Field(f.Index).SetInt(SignExtend(v, f.Bits))
+	} else {
+		r.v.Field(f.Index).SetUint(v)
+	}
+}
+
+func (r structRecord) setBool(f *FieldLayout, v bool) {
+	if f.Name != "_" {
+		r.v.Field(f.Index).SetBool(v)
+	}
+}
+
+func (r structRecord) setBytes(f *FieldLayout, v []byte) {
+	if f.Name != "_" {
+		field := r.v.Field(f.Index)
+		field.Set(reflect.ValueOf(v).Convert(field.Type()))
+	}
+}
+
+func (r structRecord) setString(f *FieldLayout, v string) {
+	if f.Name != "_" {
+		r.v.Field(f.Index).SetString(v)
+	}
+}
+
+func (r structRecord) makeSlice(f *FieldLayout) {
+	if f.Name != "_" {
+		field := r.v.Field(f.Index)
+		field.Set(reflect.MakeSlice(field.Type(), 0, 0))
+	}
+}
+
+func (r structRecord) elem(f *FieldLayout) record {
+	return structRecord{reflect.New(r.v.Type().Field(f.Index).Type.Elem()).Elem()}
+}
+
+func (r structRecord) appendElem(f *FieldLayout, elem record) {
+	if f.Name != "_" {
+		field := r.v.Field(f.Index)
+		field.Set(reflect.Append(field, elem.(structRecord).v))
+	}
+}
+
+func (r structRecord) uint(name string) (uint64, bool) {
+	field := r.v.FieldByName(name)
+	switch field.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return field.Uint(), true
+	}
+	return 0, false
+}
+
+func (r structRecord) fieldType(f *FieldLayout) reflect.Type {
+	return r.v.Type().Field(f.Index).Type
+}
+
+func (r structRecord) elemSize(f *FieldLayout) uint64 {
+	return uint64(r.v.Type().Field(f.Index).Type.Elem().Size())
+}
+
+// mapRecord decodes fields into a map keyed by field name. Fields named "_"
+// are left out.
+type mapRecord map[string]interface{}
+
+func (r mapRecord) set(f *FieldLayout, v interface{}) {
+	if f.Name != "_" {
+		r[f.Name] = v
+	}
+}
+
+func (r mapRecord) setBool(f *FieldLayout, v bool)     { r.set(f, v) }
+func (r mapRecord) setBytes(f *FieldLayout, v []byte)  { r.set(f, v) }
+func (r mapRecord) setString(f *FieldLayout, v string) { r.set(f, v) }
+
+// setUint sets f to v, or to an int64 if f is signed.
+func (r mapRecord) setUint(f *FieldLayout, v uint64) {
+	if f.Signed {
+		r.set(f, SignExtend(v, f.Bits))
+	} else {
+		r.set(f, v)
+	}
+}
+
+func (r mapRecord) makeSlice(f *FieldLayout) {
+	r.set(f, []map[string]interface{}{})
+}
+
+func (r mapRecord) elem(f *FieldLayout) record {
+	return mapRecord{}
+}
+
+func (r mapRecord) appendElem(f *FieldLayout, elem record) {
+	if f.Name != "_" {
+		r[f.Name] = append(r[f.Name].([]map[string]interface{}), elem.(mapRecord))
+	}
+}
+
+func (r mapRecord) uint(name string) (uint64, bool) {
+	v, ok := r[name].(uint64)
+	return v, ok
+}
+
+func (r mapRecord) fieldType(f *FieldLayout) reflect.Type {
+	return nil
+}
+
+func (r mapRecord) elemSize(f *FieldLayout) uint64 {
+	// Roughly a map header plus a key and a value per field.
+	return uint64(48 + 32*len(f.Elem.Fields))
+}
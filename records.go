@@ -0,0 +1,61 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"errors"
+	"iter"
+	"reflect"
+)
+
+// Records returns an iterator over the records of type T decoded one at a
+// time from the buffer of d. T must be a struct type. Iteration stops when the
+// buffer is exhausted, but for the padding of its last byte as in DecodeAll,
+// or after yielding the first error.
+//
+//	for rec, err := range bitstring.Records[Utmpx](d) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func Records[T any](d *Decoder) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var rec T
+		v := reflect.ValueOf(&rec).Elem()
+		if v.Kind() != reflect.Struct {
+			yield(rec, errors.New("bitarray.Records: invalid type "+v.Type().String()))
+			return
+		}
+		for {
+			more, err := d.more()
+			if err != nil {
+				yield(rec, err)
+				return
+			}
+			if !more {
+				return
+			}
+			if err := d.decodeStruct(v); err != nil {
+				yield(rec, err)
+				return
+			}
+			if !yield(rec, nil) {
+				return
+			}
+		}
+	}
+}
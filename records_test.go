@@ -0,0 +1,114 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRecords(t *testing.T) {
+	type S struct {
+		F1 uint8  `bits:"4"`
+		F2 uint16 `bits:"12"`
+	}
+
+	var data = []byte{
+		0x1f, 0xff, // 0001|1111,1111,1111
+		0x20, 0x01, // 0010|0000,0000,0001
+		0x30, 0x10, // 0011|0000,0001,0000
+	}
+
+	d := NewDecoder(NewBuffer(bytes.NewBuffer(data)))
+	var out []S
+	for rec, err := range Records[S](d) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, rec)
+	}
+
+	want := []S{
+		{F1: 0x1, F2: 0xfff},
+		{F1: 0x2, F2: 0x001},
+		{F1: 0x3, F2: 0x010},
+	}
+	if !reflect.DeepEqual(want, out) {
+		t.Errorf("want=%#v, out: %#v", want, out)
+	}
+}
+
+func TestRecordsPadding(t *testing.T) {
+	type S struct {
+		A uint16 `bits:"12"`
+	}
+	for _, strict := range []bool{false, true} {
+		d := NewDecoder(NewBuffer(bytes.NewBuffer([]byte{0xab, 0xc0})), DecoderOptions{StrictEOF: strict})
+		var out []S
+		for rec, err := range Records[S](d) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, rec)
+		}
+		if want := []S{{A: 0xabc}}; !reflect.DeepEqual(want, out) {
+			t.Errorf("strict %v: want=%#v, out: %#v", strict, want, out)
+		}
+	}
+}
+
+func TestRecordsBreak(t *testing.T) {
+	type S struct {
+		F1 uint8 `bits:"8"`
+	}
+
+	d := NewDecoder(NewBuffer(bytes.NewBuffer([]byte{0x01, 0x02, 0x03})))
+	for rec, err := range Records[S](d) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.F1 == 0x02 {
+			break
+		}
+	}
+
+	// The iteration resumes from the record following the last one yielded.
+	var out []uint8
+	for rec, err := range Records[S](d) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, rec.F1)
+	}
+	if !reflect.DeepEqual([]uint8{0x03}, out) {
+		t.Errorf("want=%#v, out: %#v", []uint8{0x03}, out)
+	}
+}
+
+func TestRecordsInvalidType(t *testing.T) {
+	d := NewDecoder(NewBuffer(bytes.NewBuffer([]byte{0x01})))
+	n := 0
+	for _, err := range Records[int](d) {
+		if err == nil {
+			t.Error("want error for non-struct type")
+		}
+		n++
+	}
+	if n != 1 {
+		t.Errorf("want 1 error, out: %d", n)
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring_test
+
+import (
+	"testing"
+	"testing/quick"
+
+	"github.com/ymotongpoo/go-bitstring/bitstringtest"
+)
+
+// popWidths has fields of every width handled separately by PopUint8 to
+// PopUint64, within a byte, across one byte border and across several, at
+// varying bit positions. Pops cut short by the end of the buffer are
+// covered by the TestPopUint*Case* tests in operate_test.go.
+type popWidths struct {
+	U3  uint8  `bits:"3"`
+	U8  uint8  `bits:"8"`
+	U5  uint8  `bits:"5"`
+	U7  uint8  `bits:"7"`
+	U11 uint16 `bits:"11"`
+	U15 uint16 `bits:"15"`
+	U16 uint16 `bits:"16"`
+	U20 uint32 `bits:"20"`
+	U23 uint32 `bits:"23"`
+	U32 uint32 `bits:"32"`
+	U43 uint64 `bits:"43"`
+	U55 uint64 `bits:"55"`
+	U64 uint64 `bits:"64"`
+	U1  bool   `bits:"1"`
+}
+
+func TestPopRoundTrip(t *testing.T) {
+	f := func(v popWidths) bool { return bitstringtest.AssertRoundTrip(t, &v) }
+	if err := quick.Check(f, &quick.Config{MaxCount: 1000, Values: bitstringtest.Values(f)}); err != nil {
+		t.Error(err)
+	}
+}
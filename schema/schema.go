@@ -0,0 +1,171 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// Package schema loads bit array layouts from YAML documents, so that a
+// format can be shared with programs not written in Go and decoded without a
+// tagged struct. A schema looks like
+//
+//	name: packet
+//	types:
+//	  option:
+//	    - {name: type, type: uint, bits: 4, enum: {1: pad, 2: mss}}
+//	    - {name: _, type: uint, bits: 4}
+//	fields:
+//	  - {name: version, type: uint, bits: 4}
+//	  - {name: n, type: uint, bits: 4}
+//	  - {name: options, type: option, count: n}
+//	  - {name: host, type: string, size: 16, mode: cstring}
+//	  - {name: body, type: bytes, until: eof}
+//
+// Field types are uint, bool, bytes, string, or the name of an entry in
+// types for a slice of records. bits gives the width of uint and bool
+// fields, and must be set for them, and size the width in bytes of bytes and string fields. The other
+// keys mean the same as the tags of struct fields. The layout is decoded
+// with Decoder.DecodeMap and encoded with Encoder.EncodeMap.
+package schema
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	bitstring "github.com/ymotongpoo/go-bitstring"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUnknownType   = errors.New("bitarray: schema: unknown field type")
+	ErrRecursiveType = errors.New("bitarray: schema: type refers to itself")
+	ErrMissingBits   = errors.New("bitarray: schema: uint and bool fields need bits")
+)
+
+// A Schema is the document form of a layout.
+type Schema struct {
+	Name   string             `yaml:"name"`
+	Types  map[string][]Field `yaml:"types"`
+	Fields []Field            `yaml:"fields"`
+}
+
+// A Field is the document form of a field layout.
+type Field struct {
+	Name    string            `yaml:"name"`
+	Type    string            `yaml:"type"`
+	Bits    int               `yaml:"bits"`
+	Size    int               `yaml:"size"`
+	Mode    string            `yaml:"mode"`
+	Charset string            `yaml:"charset"`
+	Count   string            `yaml:"count"`
+	Until   string            `yaml:"until"`
+	Enum    map[uint64]string `yaml:"enum"`
+}
+
+// Parse parses a YAML schema and returns its layout. Keys not described
+// by Schema and Field are errors.
+func Parse(data []byte) (*bitstring.StructLayout, error) {
+	var s Schema
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return s.Layout()
+}
+
+// Load reads a YAML schema from the named file and returns its layout.
+func Load(name string) (*bitstring.StructLayout, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Layout builds the layout described by s. The error is a
+// *bitstring.FieldError for the first invalid field.
+func (s *Schema) Layout() (*bitstring.StructLayout, error) {
+	r := resolver{schema: s, built: make(map[string]*bitstring.StructLayout)}
+	return r.layout(s.Name, s.Fields)
+}
+
+type resolver struct {
+	schema *Schema
+	built  map[string]*bitstring.StructLayout // nil while a type is being built
+}
+
+func (r *resolver) layout(name string, fields []Field) (*bitstring.StructLayout, error) {
+	layouts := make([]bitstring.FieldLayout, 0, len(fields))
+	for _, f := range fields {
+		fl := bitstring.FieldLayout{
+			Name:    f.Name,
+			Bits:    f.Bits,
+			Mode:    f.Mode,
+			Charset: f.Charset,
+			Count:   f.Count,
+			Until:   f.Until,
+			Enum:    f.Enum,
+		}
+		switch f.Type {
+		case "uint", "bool":
+			if f.Bits <= 0 {
+				return nil, &bitstring.FieldError{Field: f.Name, Err: ErrMissingBits}
+			}
+			fl.Kind = bitstring.UintKind
+			if f.Type == "bool" {
+				fl.Kind = bitstring.BoolKind
+			}
+		case "bytes":
+			fl.Kind = bitstring.BytesKind
+			fl.Bits = f.Size * 8
+		case "string":
+			fl.Kind = bitstring.StringKind
+			fl.Bits = f.Size * 8
+		default:
+			elem, err := r.elem(f.Type)
+			if _, ok := err.(*bitstring.FieldError); ok {
+				return nil, err
+			}
+			if err != nil {
+				return nil, &bitstring.FieldError{Field: f.Name, Err: err}
+			}
+			fl.Kind = bitstring.SliceKind
+			fl.Elem = elem
+		}
+		layouts = append(layouts, fl)
+	}
+	return bitstring.NewLayout(name, layouts)
+}
+
+// elem returns the layout of the named type, building it on first use.
+func (r *resolver) elem(name string) (*bitstring.StructLayout, error) {
+	if l, ok := r.built[name]; ok {
+		if l == nil {
+			return nil, ErrRecursiveType
+		}
+		return l, nil
+	}
+	fields, ok := r.schema.Types[name]
+	if !ok {
+		return nil, ErrUnknownType
+	}
+	r.built[name] = nil
+	l, err := r.layout(name, fields)
+	if err != nil {
+		delete(r.built, name)
+		return nil, err
+	}
+	r.built[name] = l
+	return l, nil
+}
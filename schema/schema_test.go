@@ -0,0 +1,110 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package schema
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	bitstring "github.com/ymotongpoo/go-bitstring"
+)
+
+const packet = `
+name: packet
+types:
+  option:
+    - {name: type, type: uint, bits: 4, enum: {1: pad, 2: mss}}
+    - {name: _, type: uint, bits: 4}
+fields:
+  - {name: version, type: uint, bits: 4}
+  - {name: n, type: uint, bits: 4}
+  - {name: options, type: option, count: n}
+  - {name: host, type: string, size: 4, mode: cstring}
+  - {name: secure, type: bool, bits: 8}
+  - {name: body, type: bytes, until: eof}
+`
+
+// Parse: Case 1) Data decoded with a schema is encoded back unchanged.
+func TestParseCase1(t *testing.T) {
+	l, err := Parse([]byte(packet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte{0x42, 0x10, 0x20, 'a', 'b', 0, 0, 0x01, 0xca, 0xfe}
+
+	m, err := bitstring.NewDecoder(bitstring.NewBuffer(bytes.NewReader(data))).DecodeMap(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"version": uint64(4),
+		"n":       uint64(2),
+		"options": []map[string]interface{}{
+			{"type": uint64(1)},
+			{"type": uint64(2)},
+		},
+		"host":   "ab",
+		"secure": true,
+		"body":   []byte{0xca, 0xfe},
+	}
+	if !reflect.DeepEqual(want, m) {
+		t.Errorf("want=%#v, out: %#v", want, m)
+	}
+
+	out := &bytes.Buffer{}
+	w := bitstring.NewWriter(out)
+	if err := bitstring.NewEncoder(w).EncodeMap(l, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, out.Bytes()) {
+		t.Errorf("want=%x, out: %x", data, out.Bytes())
+	}
+}
+
+// Parse: Case 2) Invalid schemas are reported with the offending field.
+func TestParseCase2(t *testing.T) {
+	cases := []struct {
+		schema string
+		field  string
+		want   error
+	}{
+		{"fields: [{name: a, type: float}]", "a", ErrUnknownType},
+		{"types: {t: [{name: b, type: t, until: eof}]}\nfields: [{name: a, type: t, until: eof}]", "b", ErrRecursiveType},
+		{"fields: [{name: a, type: uint, bits: 65}]", "a", bitstring.ErrFieldSizeTooLarge},
+		{"fields: [{name: a, type: bytes, count: n}]", "a", bitstring.ErrInvalidCountTag},
+		{"fields: [{name: a, type: uint, bits: 1}, {name: a, type: bool, bits: 1}]", "a", bitstring.ErrDuplicateField},
+		{"fields: [{name: v, type: uint}]", "v", ErrMissingBits},
+		{"fields: [{name: v, type: bool, bits: 0}]", "v", ErrMissingBits},
+	}
+	for _, c := range cases {
+		_, err := Parse([]byte(c.schema))
+		var fe *bitstring.FieldError
+		if !errors.As(err, &fe) || fe.Field != c.field || !errors.Is(err, c.want) {
+			t.Errorf("%s: want=%v in field %s, out: %v", c.schema, c.want, c.field, err)
+		}
+	}
+	if _, err := Parse([]byte("fields: {")); err == nil {
+		t.Error("want=YAML error, out: nil")
+	}
+	if _, err := Parse([]byte("fields: [{name: v, type: uint, bit: 4}]")); err == nil {
+		t.Error("want=error for an unknown key, out: nil")
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrInvalidBinaryTag = errors.New("bitarray: binary tag must be a byte size, a string mode or both")
+	ErrUnknownCharset   = errors.New("bitarray: unknown charset")
+	ErrUnencodable      = errors.New("bitarray: string cannot be encoded in charset")
+)
+
+// String modes that can follow the byte size in a binary tag of a string field.
+//
+//	binary:"32"          32 bytes as is
+//	binary:"32,cstring"  32 bytes, trimmed at the first NUL
+//	binary:"zstring"     bytes up to the next NUL, which is consumed
+//	binary:"pstring8"    8 bit length followed by that many bytes
+const (
+	CString   = "cstring"
+	ZString   = "zstring"
+	PString8  = "pstring8"
+	PString16 = "pstring16"
+	PString32 = "pstring32"
+)
+
+// prefixSize returns the bit size of the length prefix of mode, or 0 if mode
+// is not a length-prefixed mode.
+func prefixSize(mode string) uint64 {
+	switch mode {
+	case PString8:
+		return Uint8Size
+	case PString16:
+		return Uint16Size
+	case PString32:
+		return Uint32Size
+	}
+	return 0
+}
+
+// parseBinaryTag splits a binary tag into its byte size and string mode.
+func parseBinaryTag(tag string) (uint64, string, error) {
+	var size uint64
+	var sized bool
+	var mode string
+	for _, s := range strings.Split(tag, ",") {
+		s = strings.TrimSpace(s)
+		switch {
+		case len(s) > 0 && s[0] >= '0' && s[0] <= '9':
+			if sized {
+				return 0, "", ErrInvalidBinaryTag
+			}
+			n, err := strconv.ParseUint(s, 0, 64)
+			if err != nil {
+				return 0, "", err
+			}
+			size, sized = n, true
+		case s == CString, s == ZString, prefixSize(s) > 0:
+			if len(mode) != 0 {
+				return 0, "", ErrInvalidBinaryTag
+			}
+			mode = s
+		default:
+			return 0, "", ErrInvalidBinaryTag
+		}
+	}
+	switch mode {
+	case "", CString:
+		if !sized {
+			return 0, "", ErrInvalidBinaryTag
+		}
+	default:
+		if sized {
+			return 0, "", ErrInvalidBinaryTag
+		}
+	}
+	return size, mode, nil
+}
+
+// popString extracts the raw bytes of a string field encoded in mode.
+// size is the byte size of fixed size modes.
+func (d *Decoder) popString(size uint64, mode string) ([]byte, error) {
+	switch mode {
+	case CString:
+		if err := d.alloc(size); err != nil {
+			return []byte{}, err
+		}
+		data, err := d.buf.PopBytes(size)
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			data = data[:i]
+		}
+		return data, err
+	case ZString:
+		data := []byte{}
+		for {
+			byt, err := d.buf.PopUint8(Uint8Size)
+			if byt == 0 {
+				return data, err
+			}
+			if err := d.checkSliceLen(uint64(len(data)) + 1); err != nil {
+				return data, err
+			}
+			if err := d.alloc(1); err != nil {
+				return data, err
+			}
+			data = append(data, byt)
+			if err != nil {
+				return data, err
+			}
+		}
+	case PString8, PString16, PString32:
+		n, err := d.buf.PopUint64(prefixSize(mode))
+		if err != nil {
+			return []byte{}, err
+		}
+		if err := d.checkSliceLen(n); err != nil {
+			return []byte{}, err
+		}
+		if err := d.alloc(n); err != nil {
+			return []byte{}, err
+		}
+		return d.buf.PopBytes(n)
+	default:
+		if err := d.alloc(size); err != nil {
+			return []byte{}, err
+		}
+		return d.buf.PopBytes(size)
+	}
+}
+
+// A CharsetDecoder converts text in a character encoding into a UTF-8 string.
+type CharsetDecoder func([]byte) (string, error)
+
+// A CharsetEncoder converts a UTF-8 string into text in a character encoding.
+type CharsetEncoder func(string) ([]byte, error)
+
+var (
+	charsetMu sync.RWMutex
+	charsets  = map[string]CharsetDecoder{
+		"latin1":     decodeLatin1,
+		"iso-8859-1": decodeLatin1,
+	}
+	charsetEncoders = map[string]CharsetEncoder{
+		"latin1":     encodeLatin1,
+		"iso-8859-1": encodeLatin1,
+	}
+)
+
+// RegisterCharset makes a character encoding available by name to the charset
+// tag of string fields. Names are case insensitive. Latin-1 is registered as
+// "latin1" and "iso-8859-1"; import the charset subpackage for Shift_JIS.
+func RegisterCharset(name string, dec CharsetDecoder) {
+	charsetMu.Lock()
+	defer charsetMu.Unlock()
+	charsets[strings.ToLower(name)] = dec
+}
+
+// RegisterCharsetEncoder makes a character encoding available by name to the
+// charset tag of string fields when encoding. Names are case insensitive.
+func RegisterCharsetEncoder(name string, enc CharsetEncoder) {
+	charsetMu.Lock()
+	defer charsetMu.Unlock()
+	charsetEncoders[strings.ToLower(name)] = enc
+}
+
+// EncodeCharset converts s into text in the named charset. Text with no
+// charset is taken as UTF-8.
+func EncodeCharset(name string, s string) ([]byte, error) {
+	if len(name) == 0 {
+		return []byte(s), nil
+	}
+	charsetMu.RLock()
+	enc, ok := charsetEncoders[strings.ToLower(name)]
+	charsetMu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownCharset
+	}
+	return enc(s)
+}
+
+// DecodeCharset converts data in the named charset into a string. Data with
+// no charset is taken as UTF-8.
+func DecodeCharset(name string, data []byte) (string, error) {
+	if len(name) == 0 {
+		return string(data), nil
+	}
+	charsetMu.RLock()
+	dec, ok := charsets[strings.ToLower(name)]
+	charsetMu.RUnlock()
+	if !ok {
+		return "", ErrUnknownCharset
+	}
+	return dec(data)
+}
+
+func decodeLatin1(data []byte) (string, error) {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes), nil
+}
+
+func encodeLatin1(s string) ([]byte, error) {
+	data := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			return nil, ErrUnencodable
+		}
+		data = append(data, byte(r))
+	}
+	return data, nil
+}
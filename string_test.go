@@ -0,0 +1,100 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestParseBinaryTag(t *testing.T) {
+	cases := []struct {
+		tag  string
+		size uint64
+		mode string
+		ok   bool
+	}{
+		{"32", 32, "", true},
+		{"32,cstring", 32, CString, true},
+		{"zstring", 0, ZString, true},
+		{"pstring16", 0, PString16, true},
+		{"cstring", 0, "", false},
+		{"8,zstring", 0, "", false},
+		{"8,9", 0, "", false},
+		{"8,unknown", 0, "", false},
+	}
+	for _, c := range cases {
+		size, mode, err := parseBinaryTag(c.tag)
+		if (err == nil) != c.ok {
+			t.Errorf("%q: unexpected error: %v", c.tag, err)
+			continue
+		}
+		if c.ok && (size != c.size || mode != c.mode) {
+			t.Errorf("%q: want=(%d, %q), out=(%d, %q)", c.tag, c.size, c.mode, size, mode)
+		}
+	}
+}
+
+// Extract string fields in each string mode from a byte array.
+func TestUnmarshalString(t *testing.T) {
+	type S struct {
+		F1 string `binary:"3"`
+		F2 string `binary:"6,cstring"`
+		F3 string `binary:"zstring"`
+		F4 string `binary:"pstring8" charset:"latin1"`
+		F5 string `binary:"zstring"`
+	}
+
+	var data = []byte{
+		'a', 'b', 'c',
+		'd', 'e', 0x00, 'x', 'x', 'x',
+		'f', 'g', 'h', 0x00,
+		0x02, 'i', 0xe9,
+		'j', 'k',
+	}
+
+	buf := bytes.NewBuffer(data)
+	b := NewBuffer(buf)
+	out := &S{}
+	err := Unmarshal(b, out)
+	if err != nil && err != io.EOF {
+		t.Error(err)
+	}
+
+	want := &S{
+		F1: "abc",
+		F2: "de",
+		F3: "fgh",
+		F4: "ié",
+		F5: "jk",
+	}
+	if !reflect.DeepEqual(want, out) {
+		t.Errorf("want=%#v, out: %#v", want, out)
+	}
+}
+
+func TestUnmarshalStringUnknownCharset(t *testing.T) {
+	type S struct {
+		F1 string `binary:"2" charset:"no-such-charset"`
+	}
+
+	b := NewBuffer(bytes.NewBuffer([]byte{'a', 'b'}))
+	if err := Unmarshal(b, &S{}); err != ErrUnknownCharset {
+		t.Errorf("want=%v, out: %v", ErrUnknownCharset, err)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrStuffBit is returned by a destuffing reader when the bit after a run
+// is not the stuff bit the rule calls for.
+var ErrStuffBit = errors.New("bitarray: missing stuff bit")
+
+// A StuffRule tells where stuff bits go in a bit stream: after Run equal
+// bits, the complement bit is inserted, and it starts the next run.
+type StuffRule struct {
+	Run int  // length of the runs followed by a stuff bit, at least 2.
+	Any bool // runs of 0 bits are stuffed too, not only runs of 1 bits.
+}
+
+// Stuff rules of common protocols.
+var (
+	HDLCStuffing = StuffRule{Run: 5}            // 0 after five 1s
+	USBStuffing  = StuffRule{Run: 6}            // 0 after six 1s
+	CANStuffing  = StuffRule{Run: 5, Any: true} // complement after five equal bits
+)
+
+// stuffState follows the runs of a bit stream under a StuffRule.
+type stuffState struct {
+	rule StuffRule
+	bit  uint8 // value of the bits of the current run.
+	n    int   // length of the current run.
+}
+
+func newStuffState(rule StuffRule) stuffState {
+	if rule.Run < 2 {
+		panic("bitarray: stuff rule run shorter than 2 bits")
+	}
+	return stuffState{rule: rule}
+}
+
+// next adds bit c to the stream and reports whether a stuff bit follows it.
+func (s *stuffState) next(c uint8) bool {
+	if s.n > 0 && c == s.bit {
+		s.n++
+	} else {
+		s.bit, s.n = c, 1
+	}
+	return s.n == s.rule.Run && (s.rule.Any || c == 1)
+}
+
+// A destuffer pops the bits of a Buffer without their stuff bits.
+type destuffer struct {
+	stuffState
+	b *Buffer // stuffed bits.
+}
+
+// NewDestuffingReader returns a Buffer that pops the bits of b with the
+// stuff bits of rule removed, so that a stuffed payload can be popped or
+// decoded as usual. It returns ErrStuffBit where a stuff bit is missing. A
+// stuff bit missing at the end of b is taken as cut off by it. The Buffer
+// ends with b, even within a byte.
+func NewDestuffingReader(b *Buffer, rule StuffRule) *Buffer {
+	d := &destuffer{stuffState: newStuffState(rule), b: b}
+	return newBitReader(func() (uint64, uint64, error) {
+		c, err := d.pop()
+		return uint64(c), 1, err
+	})
+}
+
+// pop pops the next payload bit, and the stuff bit after it, if any.
+func (d *destuffer) pop() (uint8, error) {
+	if eof, err := d.b.AtEOF(); eof || err != nil {
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, err
+	}
+	c, err := d.b.PopUint8(1)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if !d.next(c) {
+		return c, nil
+	}
+	if eof, err := d.b.AtEOF(); eof || err != nil {
+		return c, err
+	}
+	s, err := d.b.PopUint8(1)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if s == c {
+		return 0, ErrStuffBit
+	}
+	d.next(s)
+	return c, nil
+}
+
+// A stuffer is a bitWriter that pushes bits to a Writer with stuff bits
+// inserted.
+type stuffer struct {
+	stuffState
+	w *Writer
+}
+
+// NewStuffingWriter returns a Writer that pushes bits to w with the stuff
+// bits of rule inserted. Its Flush passes pending bits on to w without
+// padding them, so that unstuffed bits such as a closing flag can follow
+// on w; flush w itself to write them out.
+func NewStuffingWriter(w *Writer, rule StuffRule) *Writer {
+	return NewWriter(&stuffer{stuffState: newStuffState(rule), w: w})
+}
+
+func (s *stuffer) WriteByte(c byte) error {
+	return s.push(c, Uint8Size)
+}
+
+func (s *stuffer) flush(v uint8, size uint64) error {
+	return s.push(v, size)
+}
+
+// push pushes the lowest size bits of v.
+func (s *stuffer) push(v uint8, size uint64) error {
+	for i := size; i > 0; i-- {
+		c := v >> (i - 1) & 1
+		if err := s.w.PushUint8(c, 1); err != nil {
+			return err
+		}
+		if s.next(c) {
+			if err := s.w.PushUint8(1-c, 1); err != nil {
+				return err
+			}
+			s.next(1 - c)
+		}
+	}
+	return nil
+}
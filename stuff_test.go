@@ -0,0 +1,133 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+var stuffCases = []struct {
+	rule    StuffRule
+	payload string
+	stuffed string
+}{
+	{HDLCStuffing, "0111 1110 1111 1111", "0 11111 0 1 0 11111 0 111"},
+	{HDLCStuffing, "0xff", "11111 0 111"},
+	{HDLCStuffing, "11111", "11111 0"},
+	{USBStuffing, "0xff", "111111 0 11"},
+	{CANStuffing, "0000 0111 1100 0001", "00000 1 1111 0 1 00000 1 1"},
+}
+
+func TestStuffingWriter(t *testing.T) {
+	for _, c := range stuffCases {
+		payload, want := MustParseBitString(c.payload), MustParseBitString(c.stuffed)
+		out := &bytes.Buffer{}
+		w := NewWriter(out)
+		sw := NewStuffingWriter(w, c.rule)
+		for i := 0; i < payload.Len(); i += 8 {
+			k := payload.Len() - i
+			if k > 8 {
+				k = 8
+			}
+			sw.PushUint8(uint8(payload.bits(i, k)), uint64(k))
+		}
+		if err := sw.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if w.pos != uint64(want.Len()) {
+			t.Errorf("%s: want=%d bits, out: %d", c.payload, want.Len(), w.pos)
+		}
+		w.Flush()
+		s, _ := NewBitString(out.Bytes(), want.Len())
+		if !s.Equal(want) {
+			t.Errorf("%s: want=%v, out: %v", c.payload, want, s)
+		}
+	}
+}
+
+func TestDestuffingReader(t *testing.T) {
+	for _, c := range stuffCases {
+		want := MustParseBitString(c.payload)
+		b := NewDestuffingReader(MustParseBitString(c.stuffed).Reader(), c.rule)
+		var bld builder
+		for {
+			if eof, _ := b.AtEOF(); eof {
+				break
+			}
+			v, err := b.PopUint8(1)
+			if err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+			bld.push(uint64(v), 1)
+		}
+		if out := bld.bitString(); !out.Equal(want) || b.Truncated() {
+			t.Errorf("%s: want=%v, out: %v", c.stuffed, want, out)
+		}
+	}
+
+	// The payload ends within the byte the Buffer reads.
+	b := NewDestuffingReader(MustParseBitString("11111 0 01").Reader(), HDLCStuffing)
+	if _, err := b.PopUint8(8); err != io.EOF || !b.Truncated() {
+		t.Errorf("want=%v, out: %v, %v", io.EOF, err, b.Truncated())
+	}
+
+	// Six 1 bits are an HDLC flag, not payload.
+	b = NewDestuffingReader(MustParseBitString("0111 1110").Reader(), HDLCStuffing)
+	if _, err := b.PopUint8(8); err != ErrStuffBit {
+		t.Errorf("want=%v, out: %v", ErrStuffBit, err)
+	}
+}
+
+func TestStuffingRoundTrip(t *testing.T) {
+	type S struct {
+		F1 uint8  `bits:"3"`
+		F2 uint16 `bits:"13"`
+		F3 uint32 `bits:"21"`
+		F4 bool   `bits:"1"`
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, rule := range []StuffRule{HDLCStuffing, USBStuffing, CANStuffing} {
+		for i := 0; i < 100; i++ {
+			in := S{uint8(r.Intn(1 << 3)), uint16(r.Intn(1 << 13)), uint32(r.Intn(1 << 21)), r.Intn(2) == 1}
+			if i%2 == 0 {
+				in.F2, in.F3 = 1<<13-1, 0 // long runs
+			}
+			out := &bytes.Buffer{}
+			w := NewWriter(out)
+			sw := NewStuffingWriter(w, rule)
+			if err := NewEncoder(sw).Encode(&in); err != nil {
+				t.Fatal(err)
+			}
+			sw.Flush()
+			n := w.pos
+			w.Flush()
+
+			s, _ := NewBitString(out.Bytes(), int(n))
+			var dec S
+			err := Unmarshal(NewDestuffingReader(s.Reader(), rule), &dec)
+			if err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(in, dec) {
+				t.Errorf("%+v: want=%#v, out: %#v", rule, in, dec)
+			}
+		}
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ParseBitString parses the text form of a bit string. The text is made of
+// tokens separated by commas, whose bits are concatenated:
+//
+//	1010 0011 1         binary digits
+//	0b1010_0011         binary digits, also bin=1010_0011
+//	0xa3, 0o17          hexadecimal and octal digits of 4 and 3 bits each,
+//	                    also hex=a3 and oct=17
+//	a3:9, 0xa3:9        the value of hexadecimal digits in 9 bits, 010100011
+//	uint:12=291         an unsigned integer in 12 bits
+//	int:8=-3            a two's complement integer in 8 bits
+//
+// Spaces and underscores between digits are ignored, and the empty text is
+// the empty bit string. A text of the form
+// <<...>> is instead taken as an Erlang bit syntax literal of integer
+// segments, as accepted by Build with no arguments, such as "<<10:4, 3:5>>".
+func ParseBitString(s string) (BitString, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "<<") {
+		b, err := Build(s)
+		if err != nil {
+			return BitString{}, ErrInvalidBitString
+		}
+		return b, nil
+	}
+	var bld builder
+	if len(s) == 0 {
+		return bld.bitString(), nil
+	}
+	for _, t := range strings.Split(s, ",") {
+		if err := bld.parseToken(strings.TrimSpace(t)); err != nil {
+			return BitString{}, err
+		}
+	}
+	return bld.bitString(), nil
+}
+
+// MustParseBitString is like ParseBitString but panics if s cannot be
+// parsed. It simplifies writing bit patterns in tests and tables.
+func MustParseBitString(s string) BitString {
+	b, err := ParseBitString(s)
+	if err != nil {
+		panic(`bitarray: ParseBitString(` + strconv.Quote(s) + `): ` + err.Error())
+	}
+	return b
+}
+
+// parseToken appends the bits of one token of ParseBitString.
+func (b *builder) parseToken(t string) error {
+	lower := strings.ToLower(t)
+	for _, p := range []string{"uint:", "int:"} {
+		if strings.HasPrefix(lower, p) {
+			return b.parseInt(t[len(p):], p == "int:")
+		}
+	}
+
+	base, digits := 0, t
+	for _, p := range []struct {
+		prefix string
+		base   int
+	}{
+		{"0b", 2}, {"bin=", 2}, {"0o", 8}, {"oct=", 8}, {"0x", 16}, {"hex=", 16},
+	} {
+		if strings.HasPrefix(lower, p.prefix) {
+			base, digits = p.base, t[len(p.prefix):]
+			break
+		}
+	}
+	size := -1
+	if i := strings.IndexByte(digits, ':'); i >= 0 {
+		if base != 0 && base != 16 {
+			return ErrInvalidBitString
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(digits[i+1:]))
+		if err != nil || n < 0 {
+			return ErrInvalidBitString
+		}
+		base, digits, size = 16, digits[:i], n
+	}
+	if len(t) == 0 || base == 0 && len(digits) == 0 {
+		return ErrInvalidBitString
+	}
+	if base == 0 {
+		base = 2
+	}
+
+	var d builder
+	width := map[int]int{2: 1, 8: 3, 16: 4}[base]
+	for _, c := range digits {
+		if c == '_' || unicode.IsSpace(c) {
+			continue
+		}
+		v := strings.IndexRune("0123456789abcdef", c|0x20)
+		if c < '0' || v < 0 || v >= base {
+			return ErrInvalidBitString
+		}
+		d.push(uint64(v), width)
+	}
+	if size < 0 {
+		b.pushBits(d.bitString(), 0, d.n)
+		return nil
+	}
+	// The value is right aligned in size bits, so dropped leading bits
+	// must be zero.
+	if size >= d.n {
+		b.pushBits(zeros(size-d.n), 0, size-d.n)
+		b.pushBits(d.bitString(), 0, d.n)
+		return nil
+	}
+	v := d.bitString()
+	if v.Slice(0, d.n-size).OnesCount() != 0 {
+		return ErrInvalidBitString
+	}
+	b.pushBits(v, d.n-size, d.n)
+	return nil
+}
+
+// parseInt appends the bits of the integer token size=value.
+func (b *builder) parseInt(t string, signed bool) error {
+	i := strings.IndexByte(t, '=')
+	if i < 0 {
+		return ErrInvalidBitString
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(t[:i]))
+	if err != nil || size < 0 || size > 64 {
+		return ErrInvalidBitString
+	}
+	value := strings.TrimSpace(t[i+1:])
+	var v interface{}
+	if signed {
+		v, err = strconv.ParseInt(value, 0, 64)
+	} else {
+		v, err = strconv.ParseUint(value, 0, 64)
+	}
+	if err != nil {
+		return ErrInvalidBitString
+	}
+	u, err := intArg(v, size, signed)
+	if err != nil {
+		return ErrInvalidBitString
+	}
+	b.push(u, size)
+	return nil
+}
+
+// Format implements fmt.Formatter. It accepts the verbs
+//
+//	%b      binary digits
+//	%x, %X  hexadecimal digits; if the length is not a multiple of 4, the
+//	        bits are right aligned in the digits and followed by :n, the
+//	        length, as in ParseBitString
+//	%v, %s  binary digits with the 0b prefix, as String
+//
+// The # flag adds the 0b or 0x prefix to %b and %x. The precision splits
+// the digits into groups of that many, separated by spaces; the space flag
+// does the same in groups of 8 binary or 2 hexadecimal digits, a byte
+// each. Width and the - flag pad the text as usual. The results of %v, %s,
+// %b and %#x can be parsed back with ParseBitString.
+func (s BitString) Format(f fmt.State, verb rune) {
+	var prefix, digits, suffix string
+	var group int
+	switch verb {
+	case 'b', 'v', 's':
+		prefix, digits, group = "0b", s.String()[2:], 8
+		if verb == 'b' && !f.Flag('#') {
+			prefix = ""
+		}
+	case 'x', 'X':
+		prefix, digits, group = "0x", s.hex(), 2
+		if r := s.n % 4; r != 0 {
+			suffix = ":" + strconv.Itoa(s.n)
+		}
+		if verb == 'X' {
+			prefix, digits = "0X", strings.ToUpper(digits)
+		}
+		if !f.Flag('#') {
+			prefix = ""
+		}
+	default:
+		fmt.Fprintf(f, "%%!%c(bitstring.BitString=%s)", verb, s.String())
+		return
+	}
+	if p, ok := f.Precision(); ok {
+		group = p
+	} else if !f.Flag(' ') {
+		group = 0
+	}
+
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	for i := 0; i < len(digits); i++ {
+		if group > 0 && i > 0 && i%group == 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteByte(digits[i])
+	}
+	sb.WriteString(suffix)
+
+	text := sb.String()
+	pad := ""
+	if w, ok := f.Width(); ok && w > len(text) {
+		pad = strings.Repeat(" ", w-len(text))
+	}
+	if f.Flag('-') {
+		text += pad
+	} else {
+		text = pad + text
+	}
+	fmt.Fprint(f, text)
+}
+
+// hex returns the hexadecimal digits of s, right aligned.
+func (s BitString) hex() string {
+	p := zeros((4 - s.n%4) % 4).Concat(s)
+	var sb strings.Builder
+	for i := 0; i < p.n; i += 4 {
+		sb.WriteByte("0123456789abcdef"[p.bits(i, 4)])
+	}
+	return sb.String()
+}
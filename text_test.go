@@ -0,0 +1,123 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestParseBitString(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{"0b1011_0", "0b10110", nil},
+		{"0B", "0b", nil},
+		{"0xa_5", "0b10100101", nil},
+		{"0xF", "0b1111", nil},
+		{"1010 0011 1", "0b101000111", nil},
+		{"a3:9", "0b010100011", nil},
+		{"0xA3:8", "0b10100011", nil},
+		{"0x0a3:9", "0b010100011", nil},
+		{"hex=23:6", "0b100011", nil},
+		{"0o17", "0b001111", nil},
+		{"0b1, 0x5, oct=7, bin=0", "0b101011110", nil},
+		{"uint:12=291", "0b000100100011", nil},
+		{"int:4=-3", "0b1101", nil},
+		{"<<10:4, 3:5>>", "0b101000011", nil},
+		{"0b102", "", ErrInvalidBitString},
+		{"0xg", "", ErrInvalidBitString},
+		{"", "0b", nil},
+		{"10 12", "", ErrInvalidBitString},
+		{"0b1,", "", ErrInvalidBitString},
+		{"a3:7", "", ErrInvalidBitString},
+		{"0b10:4", "", ErrInvalidBitString},
+		{"uint:4=16", "", ErrInvalidBitString},
+		{"int:4=8", "", ErrInvalidBitString},
+		{"uint:65=1", "", ErrInvalidBitString},
+		{"<<X:4>>", "", ErrInvalidBitString},
+	}
+	for _, c := range cases {
+		s, err := ParseBitString(c.in)
+		if err != c.err {
+			t.Errorf("%s: want=%v, out: %v", c.in, c.err, err)
+			continue
+		}
+		if err == nil && s.String() != c.want {
+			t.Errorf("%s: want=%s, out: %s", c.in, c.want, s)
+		}
+	}
+}
+
+func TestMustParseBitString(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("want panic")
+		}
+	}()
+	MustParseBitString("0b2")
+}
+
+func TestBitStringFormat(t *testing.T) {
+	s := MustParseBitString("1010 0011 1")
+	cases := []struct {
+		format string
+		want   string
+	}{
+		{"%b", "101000111"},
+		{"%#b", "0b101000111"},
+		{"% b", "10100011 1"},
+		{"%.4b", "1010 0011 1"},
+		{"%x", "147:9"},
+		{"%#X", "0X147:9"},
+		{"% x", "14 7:9"},
+		{"%v", "0b101000111"},
+		{"% v", "0b10100011 1"},
+		{"%s", "0b101000111"},
+		{"%12b", "   101000111"},
+		{"%-12b|", "101000111   |"},
+		{"%d", "%!d(bitstring.BitString=0b101000111)"},
+	}
+	for _, c := range cases {
+		if out := fmt.Sprintf(c.format, s); out != c.want {
+			t.Errorf("%s: want=%q, out: %q", c.format, c.want, out)
+		}
+	}
+
+	for _, in := range []string{"0xa3", "0b", "0b1", "0x5eadbeef:31"} {
+		s := MustParseBitString(in)
+		if out := fmt.Sprintf("%#x", s); out != fmt.Sprintf("%#x", MustParseBitString(out)) {
+			t.Errorf("%s: %%#x does not parse back, out: %s", in, out)
+		}
+	}
+}
+
+func TestBitStringFormatRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 70; n++ {
+		s, _ := randomBits(r, n)
+		for _, format := range []string{"%b", "%#b", "% b", "%#x", "%#.3X", "%v", "% v"} {
+			text := fmt.Sprintf(format, s)
+			out, err := ParseBitString(text)
+			if err != nil || !out.Equal(s) {
+				t.Errorf("%s of %s: want=%s, out: %s, %v", format, s, s, out, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"reflect"
+)
+
+// A Value is a decoded field together with where it was found. A tree of
+// Values describes a whole record: a RecordKind Value holds its fields in
+// order, and a SliceKind Value holds one RecordKind Value per element.
+type Value struct {
+	Name   string      `json:"name"`
+	Kind   FieldKind   `json:"kind"`
+	Offset uint64      `json:"offset"`          // bit offset from the start of the root
+	Bits   uint64      `json:"bits"`            // bit width
+	Raw    []byte      `json:"raw"`             // bits of the field, left aligned and zero padded
+	Value  interface{} `json:"value,omitempty"` // uint64, int64, bool, []byte or string
+	Enum   string      `json:"enum,omitempty"`  // name of the value of an enum field
+	Fields []*Value    `json:"fields,omitempty"`
+}
+
+// Field returns the field of v named name, or nil if there is none.
+func (v *Value) Field(name string) *Value {
+	for _, f := range v.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// DecodeValue decodes a record laid out as l into a tree of Values. l is a
+// layout returned by Layout for a struct type, or built by NewLayout. On error
+// the fields decoded so far are returned along with it.
+func (d *Decoder) DecodeValue(l *StructLayout) (*Value, error) {
+	raw := &bytes.Buffer{}
+	w := NewWriter(raw)
+	prev := d.buf.rec
+	d.buf.rec = w
+	defer func() { d.buf.rec = prev }()
+
+	r := valueRecord{
+		v:     &Value{Name: l.Name, Kind: RecordKind},
+		d:     d,
+		start: d.buf.pos,
+	}
+	err := d.decodeRecord(l, r)
+	r.v.Bits = d.buf.pos - r.start
+	w.Flush()
+	setRaw(r.v, raw.Bytes())
+	return r.v, err
+}
+
+// setRaw sets Raw of v and its fields from the bits of the whole record.
+func setRaw(v *Value, data []byte) {
+	v.Raw = rawBits(data, v.Offset, v.Bits)
+	for _, f := range v.Fields {
+		setRaw(f, data)
+	}
+}
+
+// rawBits returns size bits of data from bit offset pos, left aligned in a
+// new slice. Bits past the end of data are zero.
+func rawBits(data []byte, pos, size uint64) []byte {
+	out := make([]byte, (size+Uint8Size-1)/Uint8Size)
+	for i := uint64(0); i < size; i++ {
+		p := pos + i
+		if p/Uint8Size >= uint64(len(data)) {
+			break
+		}
+		if data[p/Uint8Size]>>(7-p%Uint8Size)&1 != 0 {
+			out[i/Uint8Size] |= 0x80 >> (i % Uint8Size)
+		}
+	}
+	return out
+}
+
+// A spanRecord is a record that is told where each of its fields starts and
+// ends.
+type spanRecord interface {
+	begin(f *FieldLayout)
+	end(f *FieldLayout)
+}
+
+// valueRecord decodes fields into a Value.
+type valueRecord struct {
+	v     *Value
+	d     *Decoder
+	start uint64 // position of the buffer at the root of the tree
+}
+
+func (r valueRecord) offset() uint64 {
+	return r.d.buf.pos - r.start
+}
+
+// last returns the field being decoded.
+func (r valueRecord) last() *Value {
+	return r.v.Fields[len(r.v.Fields)-1]
+}
+
+func (r valueRecord) begin(f *FieldLayout) {
+	r.v.Fields = append(r.v.Fields, &Value{Name: f.Name, Kind: f.Kind, Offset: r.offset()})
+}
+
+func (r valueRecord) end(f *FieldLayout) {
+	v := r.last()
+	v.Bits = r.offset() - v.Offset
+}
+
+func (r valueRecord) setUint(f *FieldLayout, v uint64) {
+	if f.Signed {
+		r.last().Value = SignExtend(v, f.Bits)
+	} else {
+		r.last().Value = v
+	}
+	if name, ok := f.Enum[v]; ok {
+		r.last().Enum = name
+	}
+}
+
+func (r valueRecord) setBool(f *FieldLayout, v bool)     { r.last().Value = v }
+func (r valueRecord) setBytes(f *FieldLayout, v []byte)  { r.last().Value = v }
+func (r valueRecord) setString(f *FieldLayout, v string) { r.last().Value = v }
+
+func (r valueRecord) makeSlice(f *FieldLayout) {
+	r.last().Fields = []*Value{}
+}
+
+func (r valueRecord) elem(f *FieldLayout) record {
+	return valueRecord{
+		v:     &Value{Name: f.Elem.Name, Kind: RecordKind, Offset: r.offset()},
+		d:     r.d,
+		start: r.start,
+	}
+}
+
+func (r valueRecord) appendElem(f *FieldLayout, elem record) {
+	e := elem.(valueRecord)
+	e.v.Bits = r.offset() - e.v.Offset
+	r.last().Fields = append(r.last().Fields, e.v)
+}
+
+func (r valueRecord) uint(name string) (uint64, bool) {
+	if f := r.v.Field(name); f != nil {
+		v, ok := f.Value.(uint64)
+		return v, ok
+	}
+	return 0, false
+}
+
+func (r valueRecord) fieldType(f *FieldLayout) reflect.Type {
+	return nil
+}
+
+func (r valueRecord) elemSize(f *FieldLayout) uint64 {
+	// Roughly a Value per field.
+	return uint64(128 * (1 + len(f.Elem.Fields)))
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeValue(t *testing.T) {
+	type E struct {
+		F1 uint8 `bits:"3" enum:"1=One,2=Two"`
+		F2 bool  `bits:"1"`
+	}
+	type S struct {
+		F1 uint8  `bits:"4"`
+		N  uint8  `bits:"4"`
+		F2 []E    `count:"N"`
+		F3 string `binary:"pstring8"`
+	}
+	l, err := Layout(reflect.TypeOf(S{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// |1010|0010|001,1|010,0|00000010|'h'|'i'|
+	data := []byte{0xa2, 0x34, 0x02, 'h', 'i'}
+	v, err := NewDecoder(NewBuffer(bytes.NewReader(data))).DecodeValue(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Value{Name: "S", Kind: RecordKind, Offset: 0, Bits: 40, Raw: data, Fields: []*Value{
+		{Name: "F1", Kind: UintKind, Offset: 0, Bits: 4, Raw: []byte{0xa0}, Value: uint64(10)},
+		{Name: "N", Kind: UintKind, Offset: 4, Bits: 4, Raw: []byte{0x20}, Value: uint64(2)},
+		{Name: "F2", Kind: SliceKind, Offset: 8, Bits: 8, Raw: []byte{0x34}, Fields: []*Value{
+			{Name: "E", Kind: RecordKind, Offset: 8, Bits: 4, Raw: []byte{0x30}, Fields: []*Value{
+				{Name: "F1", Kind: UintKind, Offset: 8, Bits: 3, Raw: []byte{0x20}, Value: uint64(1), Enum: "One"},
+				{Name: "F2", Kind: BoolKind, Offset: 11, Bits: 1, Raw: []byte{0x80}, Value: true},
+			}},
+			{Name: "E", Kind: RecordKind, Offset: 12, Bits: 4, Raw: []byte{0x40}, Fields: []*Value{
+				{Name: "F1", Kind: UintKind, Offset: 12, Bits: 3, Raw: []byte{0x40}, Value: uint64(2), Enum: "Two"},
+				{Name: "F2", Kind: BoolKind, Offset: 15, Bits: 1, Raw: []byte{0x00}, Value: false},
+			}},
+		}},
+		{Name: "F3", Kind: StringKind, Offset: 16, Bits: 24, Raw: []byte{0x02, 'h', 'i'}, Value: "hi"},
+	}}
+	if !reflect.DeepEqual(want, v) {
+		w, _ := json.Marshal(want)
+		o, _ := json.Marshal(v)
+		t.Errorf("want=%s, out: %s", w, o)
+	}
+
+	out, err := json.Marshal(v.Field("F2").Fields[0].Field("F1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantJSON := `{"name":"F1","kind":"uint","offset":8,"bits":3,"raw":"IA==","value":1,"enum":"One"}`
+	if string(out) != wantJSON {
+		t.Errorf("want=%s, out: %s", wantJSON, out)
+	}
+}
+
+func TestDecodeValueRuntime(t *testing.T) {
+	l, err := NewLayout("P", []FieldLayout{
+		{Name: "A", Kind: UintKind, Bits: 12},
+		{Name: "B", Kind: BytesKind, Until: "eof"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewDecoder(NewBuffer(bytes.NewReader([]byte{0x12, 0x34, 0x56}))).DecodeValue(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := v.Field("A"); a.Value != uint64(0x123) || !reflect.DeepEqual(a.Raw, []byte{0x12, 0x30}) {
+		t.Errorf("want=0x123, out: %#v", a)
+	}
+	// Until eof bytes start at bit 12, so the last byte is cut short and
+	// only its first 4 bits are in the data.
+	if b := v.Field("B"); b.Offset != 12 || b.Bits != 16 || !reflect.DeepEqual(b.Raw, []byte{0x45, 0x60}) {
+		t.Errorf("want=offset 12, 16 bits, out: %#v", b)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"io"
+)
+
+// A Writer is the counterpart of Buffer: it packs bits into bytes written
+// to an io.ByteWriter. Bits are written most significant first. The last
+// byte is written when it is full, or padded with zero bits by Flush.
+type Writer struct {
+	buf   io.ByteWriter // destination of packed bytes.
+	n     uint8         // number of bits pending in extra.
+	extra uint8         // pending bits, left aligned.
+	pos   uint64        // number of bits pushed so far.
+	crcs  []*CRC        // are fed the bits pushed.
+}
+
+func NewWriter(w io.ByteWriter) *Writer {
+	return &Writer{
+		buf: w,
+	}
+}
+
+// PushUint8 appends the lowest `size` bits of v to Writer.
+func (w *Writer) PushUint8(v uint8, size uint64) error {
+	if size > Uint8Size {
+		return ErrSizeTooLarge
+	}
+	return w.PushUint64(uint64(v), size)
+}
+
+// PushUint16 appends the lowest `size` bits of v to Writer.
+func (w *Writer) PushUint16(v uint16, size uint64) error {
+	if size > Uint16Size {
+		return ErrSizeTooLarge
+	}
+	return w.PushUint64(uint64(v), size)
+}
+
+// PushUint32 appends the lowest `size` bits of v to Writer.
+func (w *Writer) PushUint32(v uint32, size uint64) error {
+	if size > Uint32Size {
+		return ErrSizeTooLarge
+	}
+	return w.PushUint64(uint64(v), size)
+}
+
+// PushUint64 appends the lowest `size` bits of v to Writer.
+func (w *Writer) PushUint64(v uint64, size uint64) error {
+	if size > Uint64Size {
+		return ErrSizeTooLarge
+	}
+	w.pos += size
+	for _, c := range w.crcs {
+		c.WriteBits(v, int(size))
+	}
+	for size > 0 {
+		room := Uint8Size - uint64(w.n)
+		k := size
+		if k > room {
+			k = room
+		}
+		bits := uint8(v>>(size-k)) & uint8(1<<k-1)
+		w.extra |= bits << (room - k)
+		w.n += uint8(k)
+		size -= k
+		if w.n == uint8(Uint8Size) {
+			if err := w.buf.WriteByte(w.extra); err != nil {
+				return err
+			}
+			w.n, w.extra = 0, 0
+		}
+	}
+	return nil
+}
+
+// PushBytes appends the bytes of data to Writer.
+func (w *Writer) PushBytes(data []byte) error {
+	for _, byt := range data {
+		if err := w.PushUint8(byt, Uint8Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A bitWriter is an io.ByteWriter that transforms bits rather than bytes,
+// such as the stuffing and line code writers. It takes the pending bits of
+// a Writer at Flush, without padding.
+type bitWriter interface {
+	io.ByteWriter
+	flush(v uint8, size uint64) error
+}
+
+// Flush writes pending bits padded with zero bits to a full byte. Writers
+// that transform bits, such as that of NewStuffingWriter, pass them on
+// unpadded instead.
+func (w *Writer) Flush() error {
+	if bw, ok := w.buf.(bitWriter); ok {
+		err := bw.flush(w.extra>>(Uint8Size-uint64(w.n)), uint64(w.n))
+		w.n, w.extra = 0, 0
+		return err
+	}
+	if w.n == 0 {
+		return nil
+	}
+	w.pos += Uint8Size - uint64(w.n)
+	err := w.buf.WriteByte(w.extra)
+	w.n, w.extra = 0, 0
+	return err
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package bitstring
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// Writer: Case 1) Push bits of sizes across byte borders.
+// |111|00000,0001|1010,1|---
+func TestWriterCase1(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	if err := w.PushUint8(0x07, 3); err != nil {
+		t.Error(err)
+	}
+	if err := w.PushUint16(0x0001, 9); err != nil {
+		t.Error(err)
+	}
+	if err := w.PushUint64(0x15, 5); err != nil {
+		t.Error(err)
+	}
+	if out.Len() != 2 {
+		t.Errorf("want 2 bytes before flush, out: %d", out.Len())
+	}
+	if err := w.Flush(); err != nil {
+		t.Error(err)
+	}
+
+	want := []byte{
+		0xe0, // 1110,0000
+		0x1a, // 0001,1010
+		0x80, // 1000,0000
+	}
+	if !reflect.DeepEqual(want, out.Bytes()) {
+		t.Errorf("want=%x, out: %x", want, out.Bytes())
+	}
+}
+
+// Writer: Case 2) Bytes pushed are read back by Buffer at the same offsets.
+func TestWriterCase2(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.PushUint8(0x03, 2)
+	w.PushBytes([]byte("Hello, world"))
+	w.PushUint32(0x12345, 22)
+	w.Flush()
+
+	b := NewBuffer(bytes.NewBuffer(out.Bytes()))
+	if v, _ := b.PopUint8(2); v != 0x03 {
+		t.Errorf("want=%x, out: %x", 0x03, v)
+	}
+	if v, _ := b.PopBytes(12); string(v) != "Hello, world" {
+		t.Errorf("want=%q, out: %q", "Hello, world", v)
+	}
+	if v, _ := b.PopUint32(22); v != 0x12345 {
+		t.Errorf("want=%x, out: %x", 0x12345, v)
+	}
+}
+
+func TestWriterSizeTooLarge(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{})
+	if err := w.PushUint8(0, 9); err != ErrSizeTooLarge {
+		t.Errorf("want=%v, out: %v", ErrSizeTooLarge, err)
+	}
+	if err := w.PushUint64(0, 65); err != ErrSizeTooLarge {
+		t.Errorf("want=%v, out: %v", ErrSizeTooLarge, err)
+	}
+}